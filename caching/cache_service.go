@@ -1,12 +1,28 @@
 package caching
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/deveusss/evergram-core/config"
+
 	"github.com/maypok86/otter"
 )
 
+const (
+	CapacityEntries = "entries"
+	CapacityBytes   = "bytes"
+
+	defaultCapacity = 1000
+	// Entries must expire in a cache with per-entry TTL, this outlives any process
+	noExpiry = time.Duration(math.MaxUint32/2) * time.Second
+)
+
+// AppCacher stores encoded values by key. Get returns the encoded bytes, use GetAs or TypedCache to decode them.
 type AppCacher interface {
 	Set(key string, value interface{}) error
 	SetWithTTL(key string, value interface{}, duration time.Duration) error
@@ -14,62 +30,160 @@ type AppCacher interface {
 	Delete(key string) error
 	Clear() error
 	Has(key string) (bool, error)
+	// GetOrLoad returns the encoded value of key, calling load on a miss. Concurrent misses of a key
+	// in one process share a single load, whose errors are returned and never cached.
+	// The entries it writes carry a small header, Get returns them as stored while GetAs strips it.
+	GetOrLoad(ctx context.Context, key string, ttl time.Duration, load LoadFunc) (interface{}, error)
+}
+
+// EvictionCause tells why the cache dropped an entry on its own
+type EvictionCause uint8
+
+const (
+	EvictedBySize EvictionCause = iota // The cache was over capacity
+	EvictedByTTL                       // The entry expired
+)
+
+func (c EvictionCause) String() string {
+	if c == EvictedByTTL {
+		return "ttl"
+	}
+	return "size"
+}
+
+// EvictionListener is called with every evicted entry, on a cache maintenance goroutine
+type EvictionListener func(key string, value []byte, cause EvictionCause)
+
+// CacheOptions holds the settings of New that do not fit in a config file
+type CacheOptions struct {
+	Codec Codec // Defaults to JSONCodec
+	// Cost weighs an entry against the capacity. Defaults to 1 per entry,
+	// or the length of key and value when the capacity is in bytes.
+	Cost func(key string, value []byte) uint32
+}
+
+// CacheStats is a snapshot of an AppCache, its counters stay zero unless stats are enabled
+type CacheStats struct {
+	Hits         int64
+	Misses       int64
+	Sets         int64
+	Evictions    int64 // Entries dropped to stay within capacity
+	EvictedCost  int64
+	RejectedSets int64 // Entries costing more than the whole capacity
+	Size         int
+
+	HitRatio      float64 // Hits over lookups
+	MissRatio     float64 // Misses over lookups
+	EvictionRatio float64 // Evictions over sets
 }
 
 type AppCache struct {
-	cache *otter.Cache[string, interface{}]
+	cache otter.CacheWithVariableTTL[string, []byte]
+	codec Codec
+	ttl   time.Duration
+	stats bool
+	sets  atomic.Int64
+	loads *loadGroup
+
+	mu        sync.RWMutex
+	listeners []EvictionListener
 }
 
+// NewAppCache creates an AppCache of 1000 entries encoding values as JSON
 func NewAppCache() (*AppCache, error) {
-	// Initialize BigCache with desired configuration
-	builder, err := otter.NewBuilder[string, interface{}](1000)
-	if err != nil {
-		panic(err)
+	return NewAppCacheWithCodec(JSONCodec)
+}
+
+// NewAppCacheWithCodec creates an AppCache of 1000 entries encoding values with codec
+func NewAppCacheWithCodec(codec Codec) (*AppCache, error) {
+	return NewWithOptions(&config.CacheConfig{}, CacheOptions{Codec: codec})
+}
+
+// New creates an AppCache sized by cfg, encoding values as JSON
+func New(cfg *config.CacheConfig) (*AppCache, error) {
+	return NewWithOptions(cfg, CacheOptions{})
+}
+
+// NewWithOptions creates an AppCache sized by cfg. A zero capacity defaults to 1000 entries.
+func NewWithOptions(cfg *config.CacheConfig, options CacheOptions) (*AppCache, error) {
+	capacity := cfg.Capacity
+	if capacity == 0 {
+		capacity = defaultCapacity
+	}
+	cost := options.Cost
+	switch cfg.CapacityUnit {
+	case "", CapacityEntries:
+	case CapacityBytes:
+		if cost == nil {
+			cost = byteCost
+		}
+	default:
+		return nil, fmt.Errorf("unknown cache capacity unit %q", cfg.CapacityUnit)
+	}
+	if cfg.DefaultTTL < 0 {
+		return nil, fmt.Errorf("negative cache default TTL %s", cfg.DefaultTTL)
+	}
+	if options.Codec == nil {
+		options.Codec = JSONCodec
 	}
 
-	// StatsEnabled determines whether statistics should be calculated when the cache is running.
-	// By default, statistics calculating is disabled.
-	builder.StatsEnabled(false)
+	builder, err := otter.NewBuilder[string, []byte](capacity)
+	if err != nil {
+		return nil, err
+	}
+	if cost != nil {
+		builder.Cost(cost)
+	}
+	if cfg.Stats {
+		builder.CollectStats()
+	}
+	c := &AppCache{codec: options.Codec, ttl: cfg.DefaultTTL, stats: cfg.Stats,
+		loads: newLoadGroup(LoadOptions{StaleWhileRevalidate: cfg.StaleWhileRevalidate, NegativeTTL: cfg.NegativeTTL})}
+	builder.DeletionListener(c.notifyEviction)
 
-	// Build creates a new cache object or
-	// returns an error if invalid parameters were passed to the builder.
-	cache, err := builder.Build()
+	c.cache, err = builder.WithVariableTTL().Build()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	return c, nil
+}
+
+func byteCost(key string, value []byte) uint32 {
+	return uint32(min(uint64(len(key)+len(value)), math.MaxUint32))
+}
 
-	return &AppCache{cache: cache}, nil
+// Codec returns the codec values are encoded with
+func (c *AppCache) Codec() Codec {
+	return c.codec
 }
 
+// DefaultTTL returns how long Set keeps values, zero when they do not expire
+func (c *AppCache) DefaultTTL() time.Duration {
+	return c.ttl
+}
+
+// Set stores value for the default TTL of the cache
 func (c *AppCache) Set(key string, value interface{}) error {
-	bytes, ok := value.([]byte)
-	if !ok {
-		// value is not already a byte slice, convert it
-		var err error
-		bytes, err = json.Marshal(value)
-		if err != nil {
-			return err
-		}
-	}
-	c.cache.Set(key, bytes)
-	return nil
+	return c.SetWithTTL(key, value, c.ttl)
 }
+
+// SetWithTTL stores value for duration, a non-positive duration keeps it until evicted
 func (c *AppCache) SetWithTTL(key string, value interface{}, duration time.Duration) error {
-	bytes, ok := value.([]byte)
-	if !ok {
-		// value is not already a byte slice, convert it
-		var err error
-		bytes, err = json.Marshal(value)
-		if err != nil {
-			return err
-		}
+	bytes, err := encode(c.codec, value)
+	if err != nil {
+		return err
 	}
-	c.cache.SetWithTTL(key, bytes, duration)
+	if duration <= 0 {
+		duration = noExpiry
+	}
+	if c.stats {
+		c.sets.Add(1)
+	}
+	c.cache.Set(key, bytes, duration)
 	return nil
 }
 
 func (c *AppCache) Get(key string) (interface{}, bool) {
-	// Get value from BigCache
 	entry, ok := c.cache.Get(key)
 	if ok {
 		return entry, ok
@@ -78,7 +192,6 @@ func (c *AppCache) Get(key string) (interface{}, bool) {
 }
 
 func (c *AppCache) Delete(key string) error {
-
 	c.cache.Delete(key)
 	return nil
 }
@@ -98,3 +211,58 @@ func (c *AppCache) Has(key string) (bool, error) {
 	// Check if key exists in cache
 	return c.cache.Has(key), nil
 }
+
+func (c *AppCache) GetOrLoad(ctx context.Context, key string, ttl time.Duration, load LoadFunc) (interface{}, error) {
+	return c.loads.getOrLoad(ctx, c, key, ttl, load)
+}
+
+// Close stops the background expiry of the cache, which must not be used afterwards
+func (c *AppCache) Close() {
+	c.cache.Close()
+}
+
+// OnEviction registers listener to be called with every entry evicted from now on
+func (c *AppCache) OnEviction(listener EvictionListener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, listener)
+}
+
+func (c *AppCache) notifyEviction(key string, value []byte, cause otter.DeletionCause) {
+	var evictionCause EvictionCause
+	switch cause {
+	case otter.Size:
+		evictionCause = EvictedBySize
+	case otter.Expired:
+		evictionCause = EvictedByTTL
+	default:
+		return
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, listener := range c.listeners {
+		listener(key, value, evictionCause)
+	}
+}
+
+// Stats returns the counters of the cache
+func (c *AppCache) Stats() CacheStats {
+	stats := c.cache.Stats()
+	snapshot := CacheStats{
+		Hits:         stats.Hits(),
+		Misses:       stats.Misses(),
+		Sets:         c.sets.Load(),
+		Evictions:    stats.EvictedCount(),
+		EvictedCost:  stats.EvictedCost(),
+		RejectedSets: stats.RejectedSets(),
+		Size:         c.cache.Size(),
+	}
+	if lookups := snapshot.Hits + snapshot.Misses; lookups > 0 {
+		snapshot.HitRatio = float64(snapshot.Hits) / float64(lookups)
+		snapshot.MissRatio = float64(snapshot.Misses) / float64(lookups)
+	}
+	if snapshot.Sets > 0 {
+		snapshot.EvictionRatio = float64(snapshot.Evictions) / float64(snapshot.Sets)
+	}
+	return snapshot
+}
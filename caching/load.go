@@ -0,0 +1,145 @@
+package caching
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Entries written by GetOrLoad start with entryMagic, a kind byte and the big endian Unix nanoseconds
+// after which the value is stale, zero when it never is. No codec output starts with entryMagic.
+const (
+	entryMagic      = "\x00evg"
+	entryHeaderSize = len(entryMagic) + 1 + 8
+
+	entryValue   byte = 1
+	entryMissing byte = 2 // ErrNotFound, remembered for NegativeTTL
+)
+
+// ErrNotFound is returned by a LoadFunc for a key with no value. It is cached for NegativeTTL when that is set.
+var ErrNotFound = errors.New("not found")
+
+// LoadFunc loads the value of a key missing from the cache
+type LoadFunc func(ctx context.Context) (interface{}, error)
+
+// LoadOptions tunes GetOrLoad
+type LoadOptions struct {
+	// StaleWhileRevalidate keeps values this long past their TTL. A stale value is returned at once
+	// while a single background load refreshes it.
+	StaleWhileRevalidate time.Duration
+	NegativeTTL          time.Duration // How long ErrNotFound is remembered, zero never caches it
+}
+
+// loadGroup coalesces the concurrent loads of each key of one cache
+type loadGroup struct {
+	options LoadOptions
+	flight  singleflight.Group
+}
+
+func newLoadGroup(options LoadOptions) *loadGroup {
+	return &loadGroup{options: options}
+}
+
+// getOrLoad returns the encoded value of key, loading it once however many goroutines miss it together.
+// Loads run on a context that is not cancelled with ctx, so a caller giving up does not fail the others.
+// Freshness and not found results are kept in the entry itself, so each call costs a single lookup.
+func (g *loadGroup) getOrLoad(ctx context.Context, cache AppCacher, key string, ttl time.Duration, load LoadFunc) (interface{}, error) {
+	if value, ok := cache.Get(key); ok {
+		raw, _ := value.([]byte)
+		kind, staleAfter, payload, wrapped := unwrapEntry(raw)
+		switch {
+		case !wrapped:
+			// Stored with Set
+			return value, nil
+		case kind == entryMissing:
+			return nil, ErrNotFound
+		case !staleAfter.IsZero() && time.Now().After(staleAfter):
+			g.flight.DoChan(key, func() (interface{}, error) {
+				return g.load(context.WithoutCancel(ctx), cache, key, ttl, load)
+			})
+		}
+		return payload, nil
+	}
+
+	result := g.flight.DoChan(key, func() (interface{}, error) {
+		return g.load(context.WithoutCancel(ctx), cache, key, ttl, load)
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case loaded := <-result:
+		return loaded.Val, loaded.Err
+	}
+}
+
+// load runs load and caches its value, or its ErrNotFound. Other errors are returned without being cached.
+// Failing to store the result does not fail the load, like a failed Get it only costs a later miss.
+func (g *loadGroup) load(ctx context.Context, cache AppCacher, key string, ttl time.Duration, load LoadFunc) (interface{}, error) {
+	value, err := load(ctx)
+	if errors.Is(err, ErrNotFound) {
+		if g.options.NegativeTTL > 0 {
+			_ = cache.SetWithTTL(key, wrapEntry(entryMissing, time.Time{}, nil), g.options.NegativeTTL)
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := encode(codecOf(cache), value)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case ttl <= 0:
+		_ = cache.Set(key, wrapEntry(entryValue, time.Time{}, bytes))
+	case g.options.StaleWhileRevalidate > 0:
+		_ = cache.SetWithTTL(key, wrapEntry(entryValue, time.Now().Add(ttl), bytes), ttl+g.options.StaleWhileRevalidate)
+	default:
+		_ = cache.SetWithTTL(key, wrapEntry(entryValue, time.Time{}, bytes), ttl)
+	}
+	return bytes, nil
+}
+
+func wrapEntry(kind byte, staleAfter time.Time, payload []byte) []byte {
+	entry := make([]byte, entryHeaderSize, entryHeaderSize+len(payload))
+	copy(entry, entryMagic)
+	entry[len(entryMagic)] = kind
+	if !staleAfter.IsZero() {
+		binary.BigEndian.PutUint64(entry[len(entryMagic)+1:], uint64(staleAfter.UnixNano()))
+	}
+	return append(entry, payload...)
+}
+
+// unwrapEntry splits an entry written by GetOrLoad, reporting false for anything else
+func unwrapEntry(entry []byte) (kind byte, staleAfter time.Time, payload []byte, ok bool) {
+	if len(entry) < entryHeaderSize || string(entry[:len(entryMagic)]) != entryMagic {
+		return 0, time.Time{}, nil, false
+	}
+	if nanos := binary.BigEndian.Uint64(entry[len(entryMagic)+1:]); nanos != 0 {
+		staleAfter = time.Unix(0, int64(nanos))
+	}
+	return entry[len(entryMagic)], staleAfter, entry[entryHeaderSize:], true
+}
+
+// GetOrLoadAs returns the value of key decoded to T, loading it with load on a miss
+func GetOrLoadAs[T any](ctx context.Context, cache AppCacher, key string, ttl time.Duration, load func(context.Context) (T, error)) (T, error) {
+	codec := codecOf(cache)
+	value, err := cache.GetOrLoad(ctx, key, ttl, func(ctx context.Context) (interface{}, error) {
+		loaded, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// Encoded here, the cache would store a []byte T as it is
+		return codec.Marshal(loaded)
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	result, _, err := decodeAs[T](codec, key, value)
+	return result, err
+}
@@ -0,0 +1,165 @@
+package caching
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/deveusss/evergram-core/config"
+)
+
+func newLoadTestCache(t *testing.T, cfg config.CacheConfig) *AppCache {
+	t.Helper()
+	cfg.Stats = true
+	cache, err := New(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cache.Close() })
+	return cache
+}
+
+func TestGetOrLoadCoalescesConcurrentMisses(t *testing.T) {
+	cache := newLoadTestCache(t, config.CacheConfig{NegativeTTL: time.Minute, StaleWhileRevalidate: time.Minute})
+	var loads atomic.Int32
+	release := make(chan struct{})
+	load := func(context.Context) (string, error) {
+		loads.Add(1)
+		<-release
+		return "value", nil
+	}
+
+	const callers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := GetOrLoadAs[string](context.Background(), cache, "k", time.Minute, load)
+			if err == nil && value != "value" {
+				err = errors.New("got " + value)
+			}
+			errs <- err
+		}()
+	}
+	// Let every caller miss before the load completes
+	for cache.Stats().Misses < callers {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := loads.Load(); got != 1 {
+		t.Fatalf("loads = %d, want 1", got)
+	}
+
+	if _, err := GetOrLoadAs[string](context.Background(), cache, "k", time.Minute, load); err != nil {
+		t.Fatal(err)
+	}
+	// One lookup per call, freshness and not found markers are not counted
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != callers {
+		t.Fatalf("stats = %d hits, %d misses; want 1, %d", stats.Hits, stats.Misses, callers)
+	}
+	if size := cache.Stats().Size; size != 1 {
+		t.Fatalf("size = %d, want 1", size)
+	}
+}
+
+func TestGetOrLoadStaleWhileRevalidate(t *testing.T) {
+	cache := newLoadTestCache(t, config.CacheConfig{StaleWhileRevalidate: time.Minute})
+	var loads atomic.Int32
+	refreshed := make(chan struct{})
+	load := func(context.Context) (int32, error) {
+		n := loads.Add(1)
+		if n == 2 {
+			defer close(refreshed)
+		}
+		return n, nil
+	}
+	ttl := 20 * time.Millisecond
+
+	if got, err := GetOrLoadAs[int32](context.Background(), cache, "k", ttl, load); err != nil || got != 1 {
+		t.Fatalf("first = %d, %v; want 1", got, err)
+	}
+	if got, _ := GetOrLoadAs[int32](context.Background(), cache, "k", ttl, load); got != 1 || loads.Load() != 1 {
+		t.Fatalf("fresh read = %d after %d loads, want 1 after 1", got, loads.Load())
+	}
+
+	time.Sleep(2 * ttl)
+	if got, err := GetOrLoadAs[int32](context.Background(), cache, "k", ttl, load); err != nil || got != 1 {
+		t.Fatalf("stale read = %d, %v; want the stale 1", got, err)
+	}
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("stale value not refreshed")
+	}
+	// The refresh stores its value after returning it
+	deadline := time.Now().Add(time.Second)
+	for {
+		got, err := GetOrLoadAs[int32](context.Background(), cache, "k", time.Minute, load)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("read after refresh = %d, want 2", got)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGetOrLoadCachesNotFound(t *testing.T) {
+	cache := newLoadTestCache(t, config.CacheConfig{NegativeTTL: time.Minute})
+	var loads atomic.Int32
+	load := func(context.Context) (string, error) {
+		loads.Add(1)
+		return "", ErrNotFound
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := GetOrLoadAs[string](context.Background(), cache, "k", time.Minute, load); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("err = %v, want %v", err, ErrNotFound)
+		}
+	}
+	if got := loads.Load(); got != 1 {
+		t.Fatalf("loads = %d, want 1", got)
+	}
+	if _, ok, err := GetAs[string](cache, "k"); ok || err != nil {
+		t.Fatalf("GetAs of a not found entry = %v, %v; want a miss", ok, err)
+	}
+}
+
+func TestGetOrLoadDoesNotCacheErrors(t *testing.T) {
+	cache := newLoadTestCache(t, config.CacheConfig{NegativeTTL: time.Minute})
+	failure := errors.New("database down")
+	var loads atomic.Int32
+	load := func(context.Context) (string, error) {
+		if loads.Add(1) == 1 {
+			return "", failure
+		}
+		return "value", nil
+	}
+
+	if _, err := GetOrLoadAs[string](context.Background(), cache, "k", time.Minute, load); !errors.Is(err, failure) {
+		t.Fatalf("err = %v, want %v", err, failure)
+	}
+	got, err := GetOrLoadAs[string](context.Background(), cache, "k", time.Minute, load)
+	if err != nil || got != "value" {
+		t.Fatalf("retry = %q, %v; want value", got, err)
+	}
+	if cached, ok, err := GetAs[string](cache, "k"); err != nil || !ok || cached != "value" {
+		t.Fatalf("GetAs = %q, %v, %v; want value", cached, ok, err)
+	}
+}
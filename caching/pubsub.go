@@ -0,0 +1,60 @@
+package caching
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// PubSub broadcasts messages between the replicas of a service
+type PubSub interface {
+	Publish(ctx context.Context, channel, message string) error
+	// Subscribe returns the messages published to channel by any replica, this one included.
+	// The returned channel is closed once ctx is done.
+	Subscribe(ctx context.Context, channel string) (<-chan string, error)
+}
+
+// RedisPubSub is a PubSub over Redis pub/sub. Messages published while a subscriber is reconnecting are lost.
+type RedisPubSub struct {
+	client redis.UniversalClient
+}
+
+func NewRedisPubSub(client redis.UniversalClient) *RedisPubSub {
+	return &RedisPubSub{client: client}
+}
+
+func (p *RedisPubSub) Publish(ctx context.Context, channel, message string) error {
+	return p.client.Publish(ctx, channel, message).Err()
+}
+
+// Subscribe returns once Redis has confirmed the subscription
+func (p *RedisPubSub) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	subscription := p.client.Subscribe(ctx, channel)
+	if _, err := subscription.Receive(ctx); err != nil {
+		subscription.Close()
+		return nil, err
+	}
+
+	messages := make(chan string)
+	go func() {
+		defer close(messages)
+		defer subscription.Close()
+		received := subscription.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-received:
+				if !ok {
+					return
+				}
+				select {
+				case messages <- message.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return messages, nil
+}
@@ -0,0 +1,253 @@
+package caching
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/deveusss/evergram-core/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultRedisTimeout = time.Second
+	clearBatchSize      = 500
+)
+
+var ErrNoNamespace = errors.New("redis cache needs a namespace")
+
+// RedisCacheOptions configures a RedisCache
+type RedisCacheOptions struct {
+	Namespace  string        // Prefix of every key, followed by a colon. Required.
+	DefaultTTL time.Duration // Expiry of entries stored with Set, zero keeps them until evicted by Redis
+	Codec      Codec         // Defaults to JSONCodec
+	Timeout    time.Duration // Bounds every command, defaults to 1s
+	Load       LoadOptions
+}
+
+// RedisCache is an AppCacher shared by every replica through Redis.
+// Its keys live under a namespace, which is all Clear removes.
+type RedisCache struct {
+	client  redis.UniversalClient
+	owned   bool // The client was created by NewRedis and is closed with the cache
+	prefix  string
+	codec   Codec
+	ttl     time.Duration
+	timeout time.Duration
+	loads   *loadGroup
+}
+
+// NewRedis connects to the Redis server of cfg and checks it answers
+func NewRedis(cfg *config.RedisConfig) (*RedisCache, error) {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultRedisTimeout
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:         cfg.Address,
+		Username:     cfg.Username,
+		Password:     string(cfg.GetPassword().Get()),
+		DB:           cfg.DB,
+		DialTimeout:  timeout,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+	})
+	cache, err := NewRedisCache(client, RedisCacheOptions{
+		Namespace:  cfg.Namespace,
+		DefaultTTL: cfg.DefaultTTL,
+		Timeout:    timeout,
+		Load:       LoadOptions{StaleWhileRevalidate: cfg.StaleWhileRevalidate, NegativeTTL: cfg.NegativeTTL},
+	})
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	cache.owned = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return cache, nil
+}
+
+// NewRedisCache creates a RedisCache on client, which stays owned by the caller
+func NewRedisCache(client redis.UniversalClient, options RedisCacheOptions) (*RedisCache, error) {
+	if options.Namespace == "" {
+		return nil, ErrNoNamespace
+	}
+	if options.Codec == nil {
+		options.Codec = JSONCodec
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = defaultRedisTimeout
+	}
+	return &RedisCache{
+		client:  client,
+		prefix:  options.Namespace + ":",
+		codec:   options.Codec,
+		ttl:     options.DefaultTTL,
+		timeout: options.Timeout,
+		loads:   newLoadGroup(options.Load),
+	}, nil
+}
+
+// Codec returns the codec values are encoded with
+func (c *RedisCache) Codec() Codec {
+	return c.codec
+}
+
+// DefaultTTL returns how long Set keeps values, zero when they do not expire
+func (c *RedisCache) DefaultTTL() time.Duration {
+	return c.ttl
+}
+
+// Client returns the Redis client of the cache
+func (c *RedisCache) Client() redis.UniversalClient {
+	return c.client
+}
+
+// Set stores value for the default TTL of the cache
+func (c *RedisCache) Set(key string, value interface{}) error {
+	return c.SetWithTTL(key, value, c.ttl)
+}
+
+// SetWithTTL stores value for duration, a non-positive duration keeps it until evicted
+func (c *RedisCache) SetWithTTL(key string, value interface{}, duration time.Duration) error {
+	bytes, err := encode(c.codec, value)
+	if err != nil {
+		return err
+	}
+	if duration < 0 {
+		duration = 0
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.client.Set(ctx, c.prefix+key, bytes, duration).Err()
+}
+
+// Get returns the encoded value of key, failures are reported as misses
+func (c *RedisCache) Get(key string) (interface{}, bool) {
+	ctx, cancel := c.context()
+	defer cancel()
+	value, ok, err := c.GetContext(ctx, key)
+	if err != nil || !ok {
+		return nil, false
+	}
+	return value, true
+}
+
+// GetContext returns the encoded value of key, telling misses from failures
+func (c *RedisCache) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := c.client.Get(ctx, c.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// GetWithTTL returns the encoded value of key and the time it has left, zero when it does not expire.
+// Failures are reported as misses.
+func (c *RedisCache) GetWithTTL(key string) (interface{}, time.Duration, bool) {
+	ctx, cancel := c.context()
+	defer cancel()
+	pipe := c.client.Pipeline()
+	get := pipe.Get(ctx, c.prefix+key)
+	pttl := pipe.PTTL(ctx, c.prefix+key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, 0, false
+	}
+	value, err := get.Bytes()
+	if err != nil {
+		return nil, 0, false
+	}
+	// PTTL answers -1 for a key without expiry and -2 for one that expired after the GET
+	remaining := pttl.Val()
+	switch {
+	case remaining == -2:
+		return nil, 0, false
+	case remaining < 0:
+		remaining = 0
+	}
+	return value, remaining, true
+}
+
+func (c *RedisCache) Delete(key string) error {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.client.Del(ctx, c.prefix+key).Err()
+}
+
+// Clear removes every key of the namespace, leaving the rest of the database alone.
+// Keys are found with SCAN, so entries written while it runs may survive.
+func (c *RedisCache) Clear() error {
+	ctx := context.Background()
+	iter := c.client.Scan(ctx, 0, escapePattern(c.prefix)+"*", clearBatchSize).Iterator()
+	keys := make([]string, 0, clearBatchSize)
+	flush := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+		ctx, cancel := c.context()
+		defer cancel()
+		err := c.client.Unlink(ctx, keys...).Err()
+		keys = keys[:0]
+		return err
+	}
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == clearBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+func (c *RedisCache) Has(key string) (bool, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	count, err := c.client.Exists(ctx, c.prefix+key).Result()
+	return count > 0, err
+}
+
+// GetOrLoad coalesces the loads of this process only, other replicas may load the same key concurrently
+func (c *RedisCache) GetOrLoad(ctx context.Context, key string, ttl time.Duration, load LoadFunc) (interface{}, error) {
+	return c.loads.getOrLoad(ctx, c, key, ttl, load)
+}
+
+// Close closes the client when the cache created it
+func (c *RedisCache) Close() error {
+	if !c.owned {
+		return nil
+	}
+	return c.client.Close()
+}
+
+func (c *RedisCache) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), c.timeout)
+}
+
+// escapePattern quotes the glob characters of a SCAN MATCH pattern
+func escapePattern(s string) string {
+	var escaped strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			escaped.WriteByte('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
@@ -0,0 +1,170 @@
+package caching
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/deveusss/evergram-core/caching/resptest"
+	"github.com/deveusss/evergram-core/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestServer(t *testing.T) *resptest.Server {
+	t.Helper()
+	server, err := resptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func newTestRedisCache(t *testing.T, server *resptest.Server, namespace string) *RedisCache {
+	t.Helper()
+	cache, err := NewRedis(&config.RedisConfig{Address: server.Addr(), Namespace: namespace})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cache.Close() })
+	return cache
+}
+
+type testProfile struct {
+	Name  string
+	Score int
+}
+
+func TestRedisCacheRoundTrip(t *testing.T) {
+	server := newTestServer(t)
+	cache := newTestRedisCache(t, server, "app")
+
+	want := testProfile{Name: "ada", Score: 42}
+	if err := cache.Set("profile", want); err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := GetAs[testProfile](cache, "profile")
+	if err != nil || !ok || got != want {
+		t.Fatalf("GetAs = %+v, %v, %v; want %+v", got, ok, err, want)
+	}
+	if raw, ok := cache.Get("profile"); !ok || string(raw.([]byte)) != `{"Name":"ada","Score":42}` {
+		t.Fatalf("Get = %s, %v", raw, ok)
+	}
+	if has, err := cache.Has("profile"); err != nil || !has {
+		t.Fatalf("Has = %v, %v", has, err)
+	}
+	if keys := server.Keys(); !reflect.DeepEqual(keys, []string{"app:profile"}) {
+		t.Fatalf("server keys = %v, want [app:profile]", keys)
+	}
+
+	if err := cache.Delete("profile"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get("profile"); ok {
+		t.Fatal("Get after Delete hit")
+	}
+}
+
+func TestRedisCacheExpiry(t *testing.T) {
+	server := newTestServer(t)
+	cache := newTestRedisCache(t, server, "app")
+
+	if err := cache.SetWithTTL("short", 1, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetWithTTL("forever", 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get("short"); !ok {
+		t.Fatal("short expired early")
+	}
+
+	server.Advance(3 * time.Second)
+	if _, ok := cache.Get("short"); ok {
+		t.Fatal("short survived its TTL")
+	}
+	if _, ok := cache.Get("forever"); !ok {
+		t.Fatal("entry without TTL expired")
+	}
+}
+
+func TestRedisCacheClearKeepsOtherNamespaces(t *testing.T) {
+	server := newTestServer(t)
+	// Glob characters in the namespace must not widen the match
+	cache := newTestRedisCache(t, server, "svc*a")
+	other := newTestRedisCache(t, server, "svcba")
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := cache.Set(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := other.Set("a", "kept"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Client().Set(context.Background(), "unrelated", "kept", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if keys := server.Keys(); !reflect.DeepEqual(keys, []string{"svcba:a", "unrelated"}) {
+		t.Fatalf("server keys after Clear = %v, want [svcba:a unrelated]", keys)
+	}
+	for _, command := range server.Commands() {
+		if command == "FLUSHALL" || command == "FLUSHDB" {
+			t.Fatalf("Clear sent %s", command)
+		}
+	}
+}
+
+func TestRedisCacheNeedsNamespace(t *testing.T) {
+	server := newTestServer(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	if _, err := NewRedisCache(client, RedisCacheOptions{}); !errors.Is(err, ErrNoNamespace) {
+		t.Fatalf("NewRedisCache err = %v, want %v", err, ErrNoNamespace)
+	}
+	if _, err := NewRedis(&config.RedisConfig{Address: server.Addr()}); !errors.Is(err, ErrNoNamespace) {
+		t.Fatalf("NewRedis err = %v, want %v", err, ErrNoNamespace)
+	}
+}
+
+func TestRedisPubSub(t *testing.T) {
+	server := newTestServer(t)
+	cache := newTestRedisCache(t, server, "app")
+	pubsub := NewRedisPubSub(cache.Client())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	messages, err := pubsub.Subscribe(ctx, "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pubsub.Publish(ctx, "events", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case message := <-messages:
+		if message != "hello" {
+			t.Fatalf("message = %q, want hello", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+	}
+
+	cancel()
+	select {
+	case _, open := <-messages:
+		if open {
+			t.Fatal("unexpected message after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscription not closed after cancel")
+	}
+}
@@ -0,0 +1,172 @@
+package resptest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const maxBulkLength = 512 << 20
+
+// readCommand reads a command sent as an array of bulk strings, or inline as words on a line
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	count, err := strconv.Atoi(line[1:])
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid multibulk length %q", line[1:])
+	}
+	args := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		header, err := readLine(r)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		if !strings.HasPrefix(header, "$") {
+			return nil, fmt.Errorf("expected '$', got %q", header)
+		}
+		length, err := strconv.Atoi(header[1:])
+		if err != nil || length < 0 || length > maxBulkLength {
+			return nil, fmt.Errorf("invalid bulk length %q", header[1:])
+		}
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, unexpected(err)
+		}
+		args = append(args, string(data[:length]))
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// unexpected reports a connection closed in the middle of a command
+func unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func writeSimple(w *bytes.Buffer, s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+func writeError(w *bytes.Buffer, s string) {
+	w.WriteString("-" + s + "\r\n")
+}
+
+func writeInt(w *bytes.Buffer, n int64) {
+	w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func writeBulk(w *bytes.Buffer, b []byte) {
+	w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func writeNull(w *bytes.Buffer) {
+	w.WriteString("$-1\r\n")
+}
+
+func writeStrings(w *bytes.Buffer, values []string) {
+	w.WriteString("*" + strconv.Itoa(len(values)) + "\r\n")
+	for _, value := range values {
+		writeBulk(w, []byte(value))
+	}
+}
+
+// Match reports whether s matches the Redis glob pattern, where * and ? match any run of bytes
+// and any byte, [...] a class of bytes negated by ^, and a backslash escapes the next byte
+func Match(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if Match(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end, matched := matchClass(pattern, s[0])
+			if !matched {
+				return false
+			}
+			pattern = pattern[end:]
+			s = s[1:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return len(s) == 0
+}
+
+// matchClass matches c against the class opening pattern, returning the length of the class
+func matchClass(pattern string, c byte) (int, bool) {
+	i := 1
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+	matched := false
+	for ; i < len(pattern) && pattern[i] != ']'; i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			matched = matched || pattern[i] == c
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			low, high := pattern[i], pattern[i+2]
+			if low > high {
+				low, high = high, low
+			}
+			matched = matched || low <= c && c <= high
+			i += 2
+		default:
+			matched = matched || pattern[i] == c
+		}
+	}
+	if i < len(pattern) {
+		i++ // Closing bracket
+	}
+	return i, matched != negate
+}
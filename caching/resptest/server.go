@@ -0,0 +1,415 @@
+// Package resptest provides an in-process stand-in for a Redis server, so code using Redis can be
+// exercised without a live one. It speaks RESP2 and keeps its data in memory.
+package resptest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server answers the string, key and pub/sub commands used by the caching package:
+// PING, SELECT, AUTH, CLIENT, GET, SET with EX or PX, DEL, UNLINK, EXISTS, PTTL, KEYS, SCAN,
+// DBSIZE, FLUSHDB, FLUSHALL, PUBLISH, SUBSCRIBE and UNSUBSCRIBE. HELLO is refused so clients fall back to RESP2.
+type Server struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	data     map[string]entry
+	offset   time.Duration // Added to the wall clock by Advance
+	clients  map[*client]bool
+	commands []string
+	closed   bool
+}
+
+// client is one connection. Replies and published messages are written under its own lock,
+// never while holding the lock of the server.
+type client struct {
+	conn     net.Conn
+	mu       sync.Mutex
+	writer   *bufio.Writer
+	channels map[string]bool // Subscriptions, guarded by the server lock
+}
+
+func (c *client) send(data []byte, flush bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.writer.Write(data); err != nil {
+		return err
+	}
+	if !flush {
+		return nil
+	}
+	return c.writer.Flush()
+}
+
+type entry struct {
+	value   []byte
+	expires time.Time // Zero when the key never expires
+}
+
+// NewServer starts a Server on a free local port
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{listener: listener, data: map[string]entry{}, clients: map[*client]bool{}}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the host:port the server listens on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the server and drops its connections
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for c := range s.clients {
+		c.conn.Close()
+	}
+	s.mu.Unlock()
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+// Advance moves the clock of the server forward, expiring keys without waiting
+func (s *Server) Advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += d
+}
+
+// Keys returns the live keys, sorted
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.matchingKeys("*")
+}
+
+// Commands returns the name of every command received so far, upper-cased
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &client{conn: conn, writer: bufio.NewWriter(conn), channels: map[string]bool{}}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.clients[c] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(c)
+	}
+}
+
+func (s *Server) handle(c *client) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+		c.conn.Close()
+	}()
+
+	reader := bufio.NewReader(c.conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				var reply bytes.Buffer
+				writeError(&reply, "ERR Protocol error: "+err.Error())
+				c.send(reply.Bytes(), true)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		reply := s.execute(c, args)
+		// Pipelined commands are answered together
+		if err := c.send(reply, reader.Buffered() == 0); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) execute(c *client, args []string) []byte {
+	var w bytes.Buffer
+	name := strings.ToUpper(args[0])
+	args = args[1:]
+
+	s.mu.Lock()
+	s.commands = append(s.commands, name)
+	if len(c.channels) > 0 {
+		s.executeSubscribed(&w, c, name, args)
+		s.mu.Unlock()
+		return w.Bytes()
+	}
+	if name == "PUBLISH" {
+		if !arity(&w, name, args, 2) {
+			s.mu.Unlock()
+			return w.Bytes()
+		}
+		subscribers := s.subscribers(args[0])
+		s.mu.Unlock()
+		// Written outside the server lock, a subscriber may be slow to read
+		var message bytes.Buffer
+		writeStrings(&message, []string{"message", args[0], args[1]})
+		for _, subscriber := range subscribers {
+			subscriber.send(message.Bytes(), true)
+		}
+		writeInt(&w, int64(len(subscribers)))
+		return w.Bytes()
+	}
+	defer s.mu.Unlock()
+	now := time.Now().Add(s.offset)
+
+	switch name {
+	case "PING":
+		if len(args) > 0 {
+			writeBulk(&w, []byte(args[0]))
+		} else {
+			writeSimple(&w, "PONG")
+		}
+	case "SELECT", "AUTH", "CLIENT":
+		writeSimple(&w, "OK")
+	case "GET":
+		if !arity(&w, name, args, 1) {
+			break
+		}
+		if e, ok := s.lookup(args[0], now); ok {
+			writeBulk(&w, e.value)
+		} else {
+			writeNull(&w)
+		}
+	case "SET":
+		s.set(&w, args, now)
+	case "DEL", "UNLINK", "EXISTS":
+		if len(args) == 0 {
+			writeError(&w, fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+			break
+		}
+		count := 0
+		for _, key := range args {
+			if _, ok := s.lookup(key, now); ok {
+				count++
+				if name != "EXISTS" {
+					delete(s.data, key)
+				}
+			}
+		}
+		writeInt(&w, int64(count))
+	case "PTTL":
+		if !arity(&w, name, args, 1) {
+			break
+		}
+		e, ok := s.lookup(args[0], now)
+		switch {
+		case !ok:
+			writeInt(&w, -2)
+		case e.expires.IsZero():
+			writeInt(&w, -1)
+		default:
+			writeInt(&w, e.expires.Sub(now).Milliseconds())
+		}
+	case "KEYS":
+		if !arity(&w, name, args, 1) {
+			break
+		}
+		s.expire(now)
+		writeStrings(&w, s.matchingKeys(args[0]))
+	case "SCAN":
+		s.scan(&w, args, now)
+	case "DBSIZE":
+		s.expire(now)
+		writeInt(&w, int64(len(s.data)))
+	case "FLUSHDB", "FLUSHALL":
+		s.data = map[string]entry{}
+		writeSimple(&w, "OK")
+	case "SUBSCRIBE", "UNSUBSCRIBE":
+		s.executeSubscribed(&w, c, name, args)
+	default:
+		writeError(&w, fmt.Sprintf("ERR unknown command '%s'", strings.ToLower(name)))
+	}
+	return w.Bytes()
+}
+
+// executeSubscribed runs the commands allowed on a connection in subscribed mode.
+// Subscription changes are confirmed before the server lock is released, ahead of any message
+// published to the new channels. No client lock is held while waiting for the server lock.
+func (s *Server) executeSubscribed(w *bytes.Buffer, c *client, name string, args []string) {
+	defer func() {
+		if name == "SUBSCRIBE" || name == "UNSUBSCRIBE" {
+			c.send(w.Bytes(), true)
+			w.Reset()
+		}
+	}()
+	switch name {
+	case "SUBSCRIBE":
+		if len(args) == 0 {
+			writeError(w, "ERR wrong number of arguments for 'subscribe' command")
+			return
+		}
+		for _, channel := range args {
+			c.channels[channel] = true
+			writeSubscription(w, "subscribe", channel, len(c.channels))
+		}
+	case "UNSUBSCRIBE":
+		if len(args) == 0 {
+			for channel := range c.channels {
+				args = append(args, channel)
+			}
+			sort.Strings(args)
+		}
+		if len(args) == 0 {
+			w.WriteString("*3\r\n")
+			writeBulk(w, []byte("unsubscribe"))
+			writeNull(w)
+			writeInt(w, 0)
+			return
+		}
+		for _, channel := range args {
+			delete(c.channels, channel)
+			writeSubscription(w, "unsubscribe", channel, len(c.channels))
+		}
+	case "PING":
+		message := ""
+		if len(args) > 0 {
+			message = args[0]
+		}
+		writeStrings(w, []string{"pong", message})
+	default:
+		writeError(w, fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT are allowed in this context", strings.ToLower(name)))
+	}
+}
+
+func (s *Server) subscribers(channel string) []*client {
+	var subscribers []*client
+	for c := range s.clients {
+		if c.channels[channel] {
+			subscribers = append(subscribers, c)
+		}
+	}
+	return subscribers
+}
+
+func (s *Server) set(w *bytes.Buffer, args []string, now time.Time) {
+	if len(args) < 2 {
+		writeError(w, "ERR wrong number of arguments for 'set' command")
+		return
+	}
+	e := entry{value: []byte(args[1])}
+	for i := 2; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		if option != "EX" && option != "PX" || i+1 == len(args) {
+			writeError(w, "ERR syntax error")
+			return
+		}
+		amount, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil || amount <= 0 {
+			writeError(w, "ERR invalid expire time in 'set' command")
+			return
+		}
+		unit := time.Millisecond
+		if option == "EX" {
+			unit = time.Second
+		}
+		e.expires = now.Add(time.Duration(amount) * unit)
+		i++
+	}
+	s.data[args[0]] = e
+	writeSimple(w, "OK")
+}
+
+// scan returns every match at once, which a client must accept as any cursor is allowed to
+func (s *Server) scan(w *bytes.Buffer, args []string, now time.Time) {
+	if len(args) == 0 {
+		writeError(w, "ERR wrong number of arguments for 'scan' command")
+		return
+	}
+	pattern := "*"
+	for i := 1; i+1 < len(args); i += 2 {
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+		default:
+			writeError(w, "ERR syntax error")
+			return
+		}
+	}
+	s.expire(now)
+	keys := s.matchingKeys(pattern)
+	w.WriteString("*2\r\n")
+	writeBulk(w, []byte("0"))
+	writeStrings(w, keys)
+}
+
+func (s *Server) lookup(key string, now time.Time) (entry, bool) {
+	e, ok := s.data[key]
+	if ok && !e.expires.IsZero() && !now.Before(e.expires) {
+		delete(s.data, key)
+		return entry{}, false
+	}
+	return e, ok
+}
+
+func (s *Server) expire(now time.Time) {
+	for key := range s.data {
+		s.lookup(key, now)
+	}
+}
+
+func (s *Server) matchingKeys(pattern string) []string {
+	keys := make([]string, 0, len(s.data))
+	for key := range s.data {
+		if Match(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func arity(w *bytes.Buffer, name string, args []string, n int) bool {
+	if len(args) != n {
+		writeError(w, fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return false
+	}
+	return true
+}
+
+func writeSubscription(w *bytes.Buffer, kind, channel string, count int) {
+	w.WriteString("*3\r\n")
+	writeBulk(w, []byte(kind))
+	writeBulk(w, []byte(channel))
+	writeInt(w, int64(count))
+}
@@ -0,0 +1,244 @@
+package caching
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultInvalidationChannel = "cache_invalidation"
+
+	defaultL1TTL     = time.Minute
+	invalidateKey    = "del"
+	invalidateAll    = "clear"
+	publishTimeout   = time.Second
+	originNameLength = 8
+)
+
+// TieredCacheOptions configures a TieredCache
+type TieredCacheOptions struct {
+	// L1TTL caps how long an entry stays in the local cache, defaults to 1 minute.
+	// It bounds how stale a replica can be after missing an invalidation.
+	L1TTL   time.Duration
+	PubSub  PubSub // Carries invalidations to the other replicas, nil when there is a single one
+	Channel string // Shared by the replicas of one L2, defaults to DefaultInvalidationChannel
+	Load    LoadOptions
+}
+
+// defaultTTLer is implemented by caches whose Set applies a default TTL, zero meaning no expiry
+type defaultTTLer interface {
+	DefaultTTL() time.Duration
+}
+
+// ttlGetter is implemented by caches that can tell how long an entry has left, zero meaning no expiry
+type ttlGetter interface {
+	GetWithTTL(key string) (interface{}, time.Duration, bool)
+}
+
+// TieredCache keeps hot entries of a shared cache in process. Reads try the local L1 then the remote L2,
+// copying L2 hits into L1 for at most L1TTL and never past their expiry in L2. Set, Delete and Clear
+// write through to L2 and tell the other replicas to evict their L1 copies over PubSub.
+type TieredCache struct {
+	local   AppCacher
+	remote  AppCacher
+	ttl     time.Duration
+	pubsub  PubSub
+	channel string
+	origin  string // Tags the invalidations of this replica, which it has already applied
+	loads   *loadGroup
+
+	detached atomic.Bool // Invalidations stopped arriving, L1 is bypassed
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewTieredCache layers local over remote. Both must encode values with the same codec.
+// When options.PubSub is set it is subscribed to before returning.
+func NewTieredCache(local, remote AppCacher, options TieredCacheOptions) (*TieredCache, error) {
+	if options.L1TTL <= 0 {
+		options.L1TTL = defaultL1TTL
+	}
+	if options.Channel == "" {
+		options.Channel = DefaultInvalidationChannel
+	}
+	origin := make([]byte, originNameLength)
+	if _, err := rand.Read(origin); err != nil {
+		return nil, err
+	}
+	c := &TieredCache{
+		local:   local,
+		remote:  remote,
+		ttl:     options.L1TTL,
+		pubsub:  options.PubSub,
+		channel: options.Channel,
+		origin:  hex.EncodeToString(origin),
+		loads:   newLoadGroup(options.Load),
+		done:    make(chan struct{}),
+	}
+	if c.pubsub == nil {
+		close(c.done)
+		return c, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	messages, err := c.pubsub.Subscribe(ctx, c.channel)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	c.cancel = cancel
+	go c.receive(ctx, messages)
+	return c, nil
+}
+
+// Codec returns the codec of the remote cache
+func (c *TieredCache) Codec() Codec {
+	return codecOf(c.remote)
+}
+
+// Set stores value in L2 with its default TTL and in L1 for at most L1TTL
+func (c *TieredCache) Set(key string, value interface{}) error {
+	return c.set(key, value, 0)
+}
+
+// SetWithTTL stores value in L2 for duration and in L1 for at most L1TTL
+func (c *TieredCache) SetWithTTL(key string, value interface{}, duration time.Duration) error {
+	return c.set(key, value, duration)
+}
+
+func (c *TieredCache) set(key string, value interface{}, duration time.Duration) error {
+	bytes, err := encode(c.Codec(), value)
+	if err != nil {
+		return err
+	}
+	if duration > 0 {
+		err = c.remote.SetWithTTL(key, bytes, duration)
+	} else {
+		err = c.remote.Set(key, bytes)
+		// The L1 copy must not outlive the L2 entry
+		if remote, ok := c.remote.(defaultTTLer); ok {
+			duration = remote.DefaultTTL()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	// Other replicas may hold the previous value
+	if err := c.publish(invalidateKey, key); err != nil {
+		c.local.Delete(key)
+		return err
+	}
+	if c.detached.Load() {
+		return nil
+	}
+	return c.local.SetWithTTL(key, bytes, c.localTTL(duration))
+}
+
+func (c *TieredCache) Get(key string) (interface{}, bool) {
+	l1 := !c.detached.Load()
+	if l1 {
+		if value, ok := c.local.Get(key); ok {
+			return value, true
+		}
+	}
+	if !l1 {
+		return c.remote.Get(key)
+	}
+	// The L1 copy must not outlive the L2 entry
+	if remote, ok := c.remote.(ttlGetter); ok {
+		value, remaining, ok := remote.GetWithTTL(key)
+		if ok {
+			c.local.SetWithTTL(key, value, c.localTTL(remaining))
+		}
+		return value, ok
+	}
+	value, ok := c.remote.Get(key)
+	if ok {
+		c.local.SetWithTTL(key, value, c.ttl)
+	}
+	return value, ok
+}
+
+// Delete removes key from both tiers and from the L1 of the other replicas
+func (c *TieredCache) Delete(key string) error {
+	if err := c.remote.Delete(key); err != nil {
+		return err
+	}
+	if err := c.local.Delete(key); err != nil {
+		return err
+	}
+	return c.publish(invalidateKey, key)
+}
+
+// Clear empties both tiers and the L1 of the other replicas
+func (c *TieredCache) Clear() error {
+	if err := c.remote.Clear(); err != nil {
+		return err
+	}
+	if err := c.local.Clear(); err != nil {
+		return err
+	}
+	return c.publish(invalidateAll, "")
+}
+
+func (c *TieredCache) Has(key string) (bool, error) {
+	if !c.detached.Load() {
+		if ok, err := c.local.Has(key); err == nil && ok {
+			return true, nil
+		}
+	}
+	return c.remote.Has(key)
+}
+
+func (c *TieredCache) GetOrLoad(ctx context.Context, key string, ttl time.Duration, load LoadFunc) (interface{}, error) {
+	return c.loads.getOrLoad(ctx, c, key, ttl, load)
+}
+
+// Close stops receiving invalidations, the layers are left open
+func (c *TieredCache) Close() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	<-c.done
+}
+
+func (c *TieredCache) localTTL(duration time.Duration) time.Duration {
+	if duration > 0 {
+		return min(duration, c.ttl)
+	}
+	return c.ttl
+}
+
+func (c *TieredCache) publish(operation, key string) error {
+	if c.pubsub == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	return c.pubsub.Publish(ctx, c.channel, c.origin+" "+operation+" "+key)
+}
+
+func (c *TieredCache) receive(ctx context.Context, messages <-chan string) {
+	defer close(c.done)
+	for message := range messages {
+		origin, rest, _ := strings.Cut(message, " ")
+		if origin == c.origin {
+			continue
+		}
+		switch operation, key, _ := strings.Cut(rest, " "); operation {
+		case invalidateKey:
+			c.local.Delete(key)
+		case invalidateAll:
+			c.local.Clear()
+		}
+	}
+	if ctx.Err() == nil {
+		// The subscription ended on its own, L1 would go stale
+		c.detached.Store(true)
+		c.local.Clear()
+	}
+}
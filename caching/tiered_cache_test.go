@@ -0,0 +1,84 @@
+package caching
+
+import (
+	"testing"
+	"time"
+
+	"github.com/deveusss/evergram-core/config"
+)
+
+// recordingCache remembers the TTL each key was last stored with
+type recordingCache struct {
+	*AppCache
+	ttls map[string]time.Duration
+}
+
+func (c *recordingCache) SetWithTTL(key string, value interface{}, duration time.Duration) error {
+	c.ttls[key] = duration
+	return c.AppCache.SetWithTTL(key, value, duration)
+}
+
+func TestTieredCacheL1NeverOutlivesL2(t *testing.T) {
+	server := newTestServer(t)
+	remote := newTestRedisCache(t, server, "app")
+	store, err := NewAppCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := &recordingCache{AppCache: store, ttls: map[string]time.Duration{}}
+	cache, err := NewTieredCache(local, remote, TieredCacheOptions{L1TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	if err := remote.SetWithTTL("short", "value", 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.SetWithTTL("forever", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"short", "forever"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Fatalf("Get(%q) missed", key)
+		}
+	}
+
+	if ttl := local.ttls["short"]; ttl <= 0 || ttl > 2*time.Second {
+		t.Fatalf("L1 TTL of short = %v, want at most the 2s left in L2", ttl)
+	}
+	if ttl := local.ttls["forever"]; ttl != time.Minute {
+		t.Fatalf("L1 TTL of forever = %v, want L1TTL", ttl)
+	}
+
+	server.Advance(3 * time.Second)
+	if _, _, ok := remote.GetWithTTL("short"); ok {
+		t.Fatal("short survived its TTL in L2")
+	}
+}
+
+func TestTieredCacheSetCapsL1ByRemoteDefaultTTL(t *testing.T) {
+	server := newTestServer(t)
+	remote, err := NewRedis(&config.RedisConfig{Address: server.Addr(), Namespace: "app", DefaultTTL: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	store, err := NewAppCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := &recordingCache{AppCache: store, ttls: map[string]time.Duration{}}
+	cache, err := NewTieredCache(local, remote, TieredCacheOptions{L1TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	if err := cache.Set("k", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := local.ttls["k"]; ttl != 2*time.Second {
+		t.Fatalf("L1 TTL = %v, want the 2s default TTL of L2", ttl)
+	}
+}
@@ -0,0 +1,131 @@
+package caching
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TypedCache stores values of type V in an AppCacher, encoding them with a codec
+// and returning decode errors instead of mismatched values
+type TypedCache[K comparable, V any] struct {
+	store AppCacher
+	codec Codec
+}
+
+// NewTypedCache creates a TypedCache on store using the codec of store
+func NewTypedCache[K comparable, V any](store AppCacher) *TypedCache[K, V] {
+	return NewTypedCacheWithCodec[K, V](store, codecOf(store))
+}
+
+// NewTypedCacheWithCodec creates a TypedCache on store encoding values with codec
+func NewTypedCacheWithCodec[K comparable, V any](store AppCacher, codec Codec) *TypedCache[K, V] {
+	return &TypedCache[K, V]{store: store, codec: codec}
+}
+
+// Get returns the value stored under key. A value that cannot be decoded to V is reported with ErrDecode.
+func (c *TypedCache[K, V]) Get(key K) (V, bool, error) {
+	value, ok := c.store.Get(cacheKey(key))
+	if !ok {
+		var zero V
+		return zero, false, nil
+	}
+	return decodeAs[V](c.codec, cacheKey(key), value)
+}
+
+func (c *TypedCache[K, V]) Set(key K, value V) error {
+	bytes, err := c.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.store.Set(cacheKey(key), bytes)
+}
+
+func (c *TypedCache[K, V]) SetWithTTL(key K, value V, duration time.Duration) error {
+	bytes, err := c.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.store.SetWithTTL(cacheKey(key), bytes, duration)
+}
+
+func (c *TypedCache[K, V]) Delete(key K) error {
+	return c.store.Delete(cacheKey(key))
+}
+
+func (c *TypedCache[K, V]) Has(key K) (bool, error) {
+	return c.store.Has(cacheKey(key))
+}
+
+// GetOrLoad returns the value of key, loading and caching it with load on a miss
+func (c *TypedCache[K, V]) GetOrLoad(ctx context.Context, key K, ttl time.Duration, load func(context.Context) (V, error)) (V, error) {
+	value, err := c.store.GetOrLoad(ctx, cacheKey(key), ttl, func(ctx context.Context) (interface{}, error) {
+		loaded, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return c.codec.Marshal(loaded)
+	})
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	result, _, err := decodeAs[V](c.codec, cacheKey(key), value)
+	return result, err
+}
+
+// GetAs returns the value stored under key in cache decoded to T with the codec of cache
+func GetAs[T any](cache AppCacher, key string) (T, bool, error) {
+	value, ok := cache.Get(key)
+	if !ok {
+		var zero T
+		return zero, false, nil
+	}
+	return decodeAs[T](codecOf(cache), key, value)
+}
+
+// decodeAs unmarshals encoded entries with codec, unwrapping those written by GetOrLoad. Only entries
+// stored unencoded are returned as they are, bytes always go through codec even when T is []byte or interface{}.
+func decodeAs[T any](codec Codec, key string, value interface{}) (T, bool, error) {
+	var result T
+	switch entry := value.(type) {
+	case []byte:
+		if kind, _, payload, wrapped := unwrapEntry(entry); wrapped {
+			if kind == entryMissing {
+				return result, false, nil
+			}
+			entry = payload
+		}
+		if err := codec.Unmarshal(entry, &result); err != nil {
+			return result, false, fmt.Errorf("%w: %s: %w", ErrDecode, key, err)
+		}
+		return result, true, nil
+	case T:
+		return entry, true, nil
+	default:
+		return result, false, fmt.Errorf("%w: %s holds %T", ErrDecode, key, value)
+	}
+}
+
+// encode passes byte slices through and encodes anything else with codec
+func encode(codec Codec, value interface{}) ([]byte, error) {
+	if bytes, ok := value.([]byte); ok {
+		return bytes, nil
+	}
+	return codec.Marshal(value)
+}
+
+// codecOf returns the codec of cache, JSON for caches that do not expose one
+func codecOf(cache AppCacher) Codec {
+	if withCodec, ok := cache.(interface{ Codec() Codec }); ok {
+		return withCodec.Codec()
+	}
+	return JSONCodec
+}
+
+func cacheKey[K comparable](key K) string {
+	if s, ok := any(key).(string); ok {
+		return s
+	}
+	return fmt.Sprint(key)
+}
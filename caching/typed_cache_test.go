@@ -0,0 +1,60 @@
+package caching
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestAppCache(t *testing.T, codec Codec) *AppCache {
+	t.Helper()
+	cache, err := NewAppCacheWithCodec(codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cache.Close() })
+	return cache
+}
+
+func TestTypedCacheBytesRoundTrip(t *testing.T) {
+	for name, codec := range map[string]Codec{"json": JSONCodec, "gob": GobCodec, "msgpack": MsgpackCodec} {
+		t.Run(name, func(t *testing.T) {
+			cache := NewTypedCache[string, []byte](newTestAppCache(t, codec))
+			want := []byte{1, 2, 3}
+			if err := cache.Set("k", want); err != nil {
+				t.Fatal(err)
+			}
+			got, ok, err := cache.Get("k")
+			if err != nil || !ok || !bytes.Equal(got, want) {
+				t.Fatalf("Get = %v, %v, %v; want %v", got, ok, err, want)
+			}
+		})
+	}
+}
+
+func TestTypedCacheAnyRoundTrip(t *testing.T) {
+	cache := NewTypedCache[string, any](newTestAppCache(t, JSONCodec))
+	want := map[string]interface{}{"name": "ada", "tags": []interface{}{"a", "b"}}
+	if err := cache.Set("k", want); err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := cache.Get("k")
+	if err != nil || !ok || !reflect.DeepEqual(got, want) {
+		t.Fatalf("Get = %#v, %v, %v; want %#v", got, ok, err, want)
+	}
+}
+
+func TestGetOrLoadAsBytes(t *testing.T) {
+	cache := newTestAppCache(t, GobCodec)
+	want := []byte{1, 2, 3}
+	for i := 0; i < 2; i++ {
+		got, err := GetOrLoadAs[[]byte](context.Background(), cache, "k", time.Minute, func(context.Context) ([]byte, error) {
+			return want, nil
+		})
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("GetOrLoadAs #%d = %v, %v; want %v", i, got, err, want)
+		}
+	}
+}
@@ -34,3 +34,24 @@ func (ex *Extendable[T]) AsSucceededResponse(message string) *SucceededResponse[
 		Result:  &ex.Value,
 	}
 }
+
+// Page is a successful response holding one page of a listing
+type Page[T any] struct {
+	Status        string `json:"status"`
+	Message       string `json:"message"`
+	Result        []T    `json:"result"`
+	NextPageToken string `json:"next_page_token,omitempty"`
+	HasMore       bool   `json:"has_more"`
+}
+
+func NewPage[T any](items []T, nextPageToken string, hasMore bool) *Page[T] {
+	if items == nil {
+		items = []T{}
+	}
+	return &Page[T]{
+		Status:        "success",
+		Result:        items,
+		NextPageToken: nextPageToken,
+		HasMore:       hasMore,
+	}
+}
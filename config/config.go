@@ -14,17 +14,84 @@ type ConfigurationBase[Configuration any] struct {
 	Config *Configuration
 }
 type DatabaseConfig struct {
-	Host           string `yaml:"host"`
-	Port           int    `yaml:"port"`
-	User           string `yaml:"user"`
-	Password       string `yaml:"password"`
-	MigrationsPath string
-	Name           string        `yaml:"name"`
-	CacheTTL       time.Duration `yaml:"cache_ttl" env-default:"5m"`
-	MaxRetries     int           `yaml:"max_retries" env-default:"3"`
-	RetryWait      time.Duration `yaml:"retry_wait" env-default:"5s"`
-	MaxIdleConns   int           `yaml:"max_idle_conns" env-default:"5"`
-	MaxOpenConns   int           `yaml:"max_open_conns" env-default:"10"`
+	Driver         string                  `yaml:"driver" env-default:"postgres"` // postgres, sqlite or mysql
+	Host           string                  `yaml:"host"`
+	Port           int                     `yaml:"port"`
+	User           string                  `yaml:"user"`
+	Password       encryption.SecureString `yaml:"password"`
+	MigrationsPath string                  `yaml:"migrations_path"`
+	Name           string                  `yaml:"name"`
+	CacheTTL       time.Duration           `yaml:"cache_ttl" env-default:"5m"`
+	MaxRetries     int                     `yaml:"max_retries" env-default:"3"`
+	RetryWait      time.Duration           `yaml:"retry_wait" env-default:"5s"`
+	MaxIdleConns   int                     `yaml:"max_idle_conns" env-default:"5"`
+	MaxOpenConns   int                     `yaml:"max_open_conns" env-default:"10"`
+
+	ConnMaxLifetime  time.Duration `yaml:"conn_max_lifetime" env-default:"30m"`
+	ConnMaxIdleTime  time.Duration `yaml:"conn_max_idle_time" env-default:"5m"`
+	ConnectTimeout   time.Duration `yaml:"connect_timeout" env-default:"10s"`
+	StatementTimeout time.Duration `yaml:"statement_timeout"` // Zero leaves the server default
+	ApplicationName  string        `yaml:"application_name"`
+	SearchPath       string        `yaml:"search_path"`
+
+	SSLMode     string `yaml:"ssl_mode" env-default:"disable"` // disable, require, verify-ca or verify-full
+	SSLRootCert string `yaml:"ssl_root_cert"`                  // Path to the CA certificate
+	SSLCert     string `yaml:"ssl_cert"`                       // Path to the client certificate
+	SSLKey      string `yaml:"ssl_key"`                        // Path to the client private key
+
+	Replicas                   []ReplicaConfig `yaml:"replicas"`
+	ReplicaPolicy              string          `yaml:"replica_policy" env-default:"round_robin"` // round_robin or least_latency
+	ReplicaHealthCheckInterval time.Duration   `yaml:"replica_health_check_interval" env-default:"10s"`
+
+	SlowQueryThreshold time.Duration `yaml:"slow_query_threshold" env-default:"200ms"` // Zero disables slow query warnings
+	LogQueries         bool          `yaml:"log_queries"`                              // Log every statement at debug level
+	LogQueryParams     bool          `yaml:"log_query_params"`                         // Log bound values instead of placeholders
+
+	TenantIsolation        bool `yaml:"tenant_isolation"`          // Scope tables with a tenant_id column to the tenant in the context
+	TenantRowLevelSecurity bool `yaml:"tenant_row_level_security"` // Also expose the tenant to Postgres policies as app.tenant_id
+
+	Cache CacheConfig `yaml:"cache"` // In-process cache behind the query cache
+}
+
+// CacheConfig sizes an in-process cache
+type CacheConfig struct {
+	Capacity     int           `yaml:"capacity" env-default:"1000"`         // Maximum number of entries, or of bytes when CapacityUnit is bytes
+	CapacityUnit string        `yaml:"capacity_unit" env-default:"entries"` // entries or bytes
+	DefaultTTL   time.Duration `yaml:"default_ttl"`                         // Expiry of entries stored without one, zero keeps them until evicted
+	Stats        bool          `yaml:"stats"`                               // Count hits, misses and evictions
+
+	StaleWhileRevalidate time.Duration `yaml:"stale_while_revalidate"` // How long GetOrLoad serves expired values while refreshing them
+	NegativeTTL          time.Duration `yaml:"negative_ttl"`           // How long GetOrLoad remembers missing values
+}
+
+// ReplicaConfig is a read-only endpoint sharing the credentials and database name of the primary
+type ReplicaConfig struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+}
+
+// GetPassword returns the database password
+func (c *DatabaseConfig) GetPassword() encryption.ISecureString {
+	return &c.Password
+}
+
+// RedisConfig connects a cache shared by the replicas of a service
+type RedisConfig struct {
+	Address    string                  `yaml:"address" env-default:"localhost:6379"`
+	Username   string                  `yaml:"username"`
+	Password   encryption.SecureString `yaml:"password"`
+	DB         int                     `yaml:"db"`
+	Namespace  string                  `yaml:"namespace"`                // Prefix of every key, required so Clear cannot reach other data
+	DefaultTTL time.Duration           `yaml:"default_ttl"`              // Expiry of entries stored without one, zero keeps them until evicted
+	Timeout    time.Duration           `yaml:"timeout" env-default:"1s"` // Bounds every command
+
+	StaleWhileRevalidate time.Duration `yaml:"stale_while_revalidate"` // How long GetOrLoad serves expired values while refreshing them
+	NegativeTTL          time.Duration `yaml:"negative_ttl"`           // How long GetOrLoad remembers missing values
+}
+
+// GetPassword returns the Redis password
+func (c *RedisConfig) GetPassword() encryption.ISecureString {
+	return &c.Password
 }
 
 type AppConfig struct {
@@ -32,6 +99,8 @@ type AppConfig struct {
 	GRPC       GRPCConfig     `yaml:"grpc"`
 	DbConfig   DatabaseConfig `yaml:"db"`
 	AuthConfig AuthConfig     `yaml:"auth"`
+	Cache      CacheConfig    `yaml:"cache"`
+	Redis      RedisConfig    `yaml:"redis"`
 }
 type JwtConfig struct {
 	TokenTTL time.Duration `yaml:"token_ttl" env-default:"1h"`
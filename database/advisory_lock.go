@@ -0,0 +1,249 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultLeaderRenewInterval = 5 * time.Second
+
+var (
+	ErrNotInTransaction = errors.New("not in a transaction")
+	ErrLockReleased     = errors.New("advisory lock already released")
+)
+
+// AdvisoryLockKey hashes name to the 64-bit key of its Postgres advisory lock
+func AdvisoryLockKey(name string) int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+	return int64(hash.Sum64())
+}
+
+// AdvisoryLock is a session-scoped Postgres advisory lock held on a dedicated connection of the pool.
+// It lasts until Unlock or until that connection is lost.
+type AdvisoryLock struct {
+	Name string
+	Key  int64
+
+	mu   sync.Mutex
+	conn *sql.Conn
+}
+
+// Lock blocks until it holds the advisory lock of name or ctx is done
+func (db *OrmDatabase) Lock(ctx context.Context, name string) (*AdvisoryLock, error) {
+	lock, acquired, err := db.acquireLock(ctx, name, "SELECT true FROM pg_advisory_lock($1)")
+	if err == nil && !acquired {
+		err = fmt.Errorf("advisory lock %q not acquired", name)
+	}
+	return lock, err
+}
+
+// TryLock takes the advisory lock of name if it is free, returning false without waiting otherwise
+func (db *OrmDatabase) TryLock(ctx context.Context, name string) (*AdvisoryLock, bool, error) {
+	return db.acquireLock(ctx, name, "SELECT pg_try_advisory_lock($1)")
+}
+
+// LockTransaction blocks until the transaction of db holds the advisory lock of name,
+// which is released when the transaction ends
+func (db *OrmDatabase) LockTransaction(ctx context.Context, name string) error {
+	if err := db.checkTransactionLock(); err != nil {
+		return err
+	}
+	return db.Orm.WithContext(ctx).Exec("SELECT pg_advisory_xact_lock(?)", AdvisoryLockKey(name)).Error
+}
+
+// TryLockTransaction takes the transaction-scoped advisory lock of name if it is free
+func (db *OrmDatabase) TryLockTransaction(ctx context.Context, name string) (bool, error) {
+	if err := db.checkTransactionLock(); err != nil {
+		return false, err
+	}
+	var acquired bool
+	err := db.Orm.WithContext(ctx).Raw("SELECT pg_try_advisory_xact_lock(?)", AdvisoryLockKey(name)).Row().Scan(&acquired)
+	return acquired, err
+}
+
+func (db *OrmDatabase) checkTransactionLock() error {
+	if name := db.Orm.Dialector.Name(); name != DriverPostgres {
+		return fmt.Errorf("advisory locks are not supported by %s", name)
+	}
+	if !db.InTransaction() {
+		return ErrNotInTransaction
+	}
+	return nil
+}
+
+func (db *OrmDatabase) acquireLock(ctx context.Context, name, query string) (*AdvisoryLock, bool, error) {
+	if dialect := db.Orm.Dialector.Name(); dialect != DriverPostgres {
+		return nil, false, fmt.Errorf("advisory locks are not supported by %s", dialect)
+	}
+	pool, err := db.Orm.DB()
+	if err != nil {
+		return nil, false, err
+	}
+	conn, err := pool.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	key := AdvisoryLockKey(name)
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, query, key).Scan(&acquired); err != nil {
+		// The lock may have been granted as the query was cancelled, never return such a connection to the pool
+		discardConn(conn)
+		return nil, false, err
+	}
+	if !acquired {
+		conn.Close()
+		return nil, false, nil
+	}
+	return &AdvisoryLock{Name: name, Key: key, conn: conn}, true, nil
+}
+
+// Held reports whether the lock is still held, which fails once its connection is lost
+func (l *AdvisoryLock) Held(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn == nil {
+		return false, nil
+	}
+	var held bool
+	err := l.conn.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_locks WHERE locktype = 'advisory'
+		AND pid = pg_backend_pid() AND granted AND classid::bigint = $1 AND objid::bigint = $2 AND objsubid = 1)`,
+		int64(uint32(l.Key>>32)), int64(uint32(l.Key))).Scan(&held)
+	return held, err
+}
+
+// Unlock releases the lock and its connection
+func (l *AdvisoryLock) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn == nil {
+		return ErrLockReleased
+	}
+	conn := l.conn
+	l.conn = nil
+
+	var released bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_advisory_unlock($1)", l.Key).Scan(&released); err != nil {
+		// Closing the session is the only other way to release the lock
+		discardConn(conn)
+		return err
+	}
+	return conn.Close()
+}
+
+// discardConn closes the session behind conn instead of returning it to the pool
+func discardConn(conn *sql.Conn) {
+	_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	_ = conn.Close()
+}
+
+// LeaderElectorOptions configures a LeaderElector
+type LeaderElectorOptions struct {
+	RenewInterval time.Duration // How often the lock is verified, or retried when not leader. Defaults to 5s.
+	// OnElected runs in its own goroutine when leadership is gained; ctx is cancelled when it is lost
+	OnElected func(ctx context.Context)
+	// OnRevoked runs when leadership is lost, including when Run returns
+	OnRevoked func()
+}
+
+// LeaderElector elects one leader among the instances sharing a name by holding its advisory lock.
+// Advisory locks do not expire: leadership lasts as long as the dedicated connection, which is
+// verified every RenewInterval.
+type LeaderElector struct {
+	db      *OrmDatabase
+	name    string
+	options LeaderElectorOptions
+	leader  atomic.Bool
+}
+
+func NewLeaderElector(db *OrmDatabase, name string, options LeaderElectorOptions) *LeaderElector {
+	if options.RenewInterval <= 0 {
+		options.RenewInterval = defaultLeaderRenewInterval
+	}
+	return &LeaderElector{db: db, name: name, options: options}
+}
+
+// IsLeader reports whether this instance currently holds the leadership
+func (e *LeaderElector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Run campaigns for leadership until ctx is cancelled, then steps down
+func (e *LeaderElector) Run(ctx context.Context) error {
+	ticker := time.NewTicker(e.options.RenewInterval)
+	defer ticker.Stop()
+
+	var lock *AdvisoryLock
+	var revoke context.CancelFunc
+	stepDown := func() {
+		e.leader.Store(false)
+		revoke()
+		if e.options.OnRevoked != nil {
+			e.options.OnRevoked()
+		}
+	}
+
+	for {
+		if lock == nil {
+			acquired, ok, err := e.db.TryLock(ctx, e.name)
+			switch {
+			case err != nil && ctx.Err() == nil:
+				e.db.slog.Warn("Error campaigning for leadership", "name", e.name, "err", err)
+			case ok:
+				lock = acquired
+				leaderCtx, cancel := context.WithCancel(ctx)
+				revoke = cancel
+				e.leader.Store(true)
+				e.db.slog.Info("Gained leadership", "name", e.name)
+				if e.options.OnElected != nil {
+					go e.options.OnElected(leaderCtx)
+				}
+			}
+		} else if held, err := e.verify(ctx, lock); (err != nil || !held) && ctx.Err() == nil {
+			// A check that cannot finish in time counts as lost, a hung connection may no longer hold the lock
+			e.db.slog.Warn("Lost leadership", "name", e.name, "err", err)
+			discardLock(lock)
+			lock = nil
+			stepDown()
+		}
+
+		select {
+		case <-ctx.Done():
+			if lock != nil {
+				unlockCtx, cancel := context.WithTimeout(context.Background(), e.options.RenewInterval)
+				if err := lock.Unlock(unlockCtx); err != nil {
+					e.db.slog.Warn("Error releasing leadership", "name", e.name, "err", err)
+				}
+				cancel()
+				stepDown()
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// verify checks lock is still held, giving up after RenewInterval
+func (e *LeaderElector) verify(ctx context.Context, lock *AdvisoryLock) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.options.RenewInterval)
+	defer cancel()
+	return lock.Held(ctx)
+}
+
+// discardLock drops a lock whose connection is suspect, closing the session releases it server side
+func discardLock(lock *AdvisoryLock) {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	if lock.conn != nil {
+		discardConn(lock.conn)
+		lock.conn = nil
+	}
+}
@@ -0,0 +1,354 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+
+	auditCallbackName = "evergram:audit"
+	auditBeforeKey    = "evergram:audit_before"
+)
+
+// AuditEntry records one change of an audited row.
+// Create the table with AuthMigrate(&AuditEntry{}).
+type AuditEntry struct {
+	ID         uint64          `gorm:"primaryKey"`
+	Table      string          `gorm:"column:table_name;not null;index:idx_audit_log_entity"`
+	PrimaryKey string          `gorm:"not null;index:idx_audit_log_entity"` // Comma separated for composite keys
+	Action     string          `gorm:"not null"`
+	Actor      string          `gorm:"index"`
+	Before     json.RawMessage // Changed columns before the change, the whole row for deletes
+	After      json.RawMessage // Changed columns after the change, the whole row for creates
+	CreatedAt  time.Time       `gorm:"not null;index"`
+}
+
+func (AuditEntry) TableName() string {
+	return "audit_log"
+}
+
+type actorKey struct{}
+
+// WithActor returns a context attributing the changes made with it to actor
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set by WithActor
+func ActorFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	actor, ok := ctx.Value(actorKey{}).(string)
+	return actor, ok
+}
+
+type auditRegistry struct {
+	mu     sync.RWMutex
+	tables map[string]bool
+}
+
+func (r *auditRegistry) audited(table string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.tables[table]
+}
+
+// auditRow is the state of one row, keyed by column
+type auditRow struct {
+	primaryKey string
+	key        []interface{} // Primary key values, nil when unset
+	values     map[string]interface{}
+}
+
+// EnableAudit records every create, update and delete made through gorm on the tables of models.
+// Raw SQL is not audited.
+func (db *OrmDatabase) EnableAudit(models ...interface{}) error {
+	for _, model := range models {
+		statement := &gorm.Statement{DB: db.Orm}
+		if err := statement.Parse(model); err != nil {
+			return err
+		}
+		db.audit.mu.Lock()
+		db.audit.tables[statement.Schema.Table] = true
+		db.audit.mu.Unlock()
+	}
+	return nil
+}
+
+// AuditHistory returns the changes recorded for the row of model with the given primary key, oldest first
+func (db *OrmDatabase) AuditHistory(ctx context.Context, model interface{}, primaryKey ...interface{}) ([]AuditEntry, error) {
+	statement := &gorm.Statement{DB: db.Orm}
+	if err := statement.Parse(model); err != nil {
+		return nil, err
+	}
+	var entries []AuditEntry
+	err := db.runRetryable(ctx, func(ctx context.Context) error {
+		return db.Orm.WithContext(ctx).
+			Where("table_name = ? AND primary_key = ?", statement.Schema.Table, joinPrimaryKey(primaryKey)).
+			Order("created_at").Order("id").
+			Find(&entries).Error
+	})
+	return entries, err
+}
+
+// registerAuditCallbacks snapshots audited rows around every write and stores the differences in audit_log,
+// on the connection of the write so the entries commit or roll back with it
+func (db *OrmDatabase) registerAuditCallbacks() error {
+	callbacks := db.Orm.Callback()
+	if err := callbacks.Create().After("gorm:create").Register(auditCallbackName, db.auditCreate); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").After(tenantCallbackName).Register(auditCallbackName+"_before", db.auditSnapshot); err != nil {
+		return err
+	}
+	if err := callbacks.Update().After("gorm:update").Register(auditCallbackName, db.auditUpdate); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().Before("gorm:delete").After(tenantCallbackName).Register(auditCallbackName+"_before", db.auditSnapshot); err != nil {
+		return err
+	}
+	return callbacks.Delete().After("gorm:delete").Register(auditCallbackName, db.auditDelete)
+}
+
+func (db *OrmDatabase) auditing(tx *gorm.DB) bool {
+	return tx.Error == nil && !tx.DryRun && tx.Statement.Schema != nil && db.audit.audited(tx.Statement.Schema.Table)
+}
+
+func (db *OrmDatabase) auditCreate(tx *gorm.DB) {
+	if !db.auditing(tx) {
+		return
+	}
+	var entries []AuditEntry
+	for _, row := range auditRows(tx.Statement, reflect.Indirect(tx.Statement.ReflectValue)) {
+		entries = append(entries, db.auditEntry(tx, AuditActionCreate, row.primaryKey, nil, row.values))
+	}
+	db.storeAudit(tx, entries)
+}
+
+// auditSnapshot loads the rows an update or delete is about to change
+func (db *OrmDatabase) auditSnapshot(tx *gorm.DB) {
+	if !db.auditing(tx) {
+		return
+	}
+	query := auditQuery(tx)
+	if query == nil {
+		return
+	}
+	rows, err := loadAuditRows(tx.Statement, query)
+	if err != nil {
+		tx.AddError(fmt.Errorf("audit snapshot of %s: %w", tx.Statement.Table, err))
+		return
+	}
+	tx.InstanceSet(auditBeforeKey, rows)
+}
+
+func (db *OrmDatabase) auditUpdate(tx *gorm.DB) {
+	before, ok := db.auditedBefore(tx)
+	if !ok {
+		return
+	}
+	after, err := loadAuditRows(tx.Statement, auditSession(tx).Where(primaryKeyCondition(tx.Statement.Schema, before)))
+	if err != nil {
+		tx.AddError(fmt.Errorf("audit snapshot of %s: %w", tx.Statement.Table, err))
+		return
+	}
+	afterByKey := make(map[string]map[string]interface{}, len(after))
+	for _, row := range after {
+		afterByKey[row.primaryKey] = row.values
+	}
+
+	var entries []AuditEntry
+	for _, row := range before {
+		changedBefore, changedAfter := auditDiff(row.values, afterByKey[row.primaryKey])
+		if len(changedAfter) > 0 {
+			entries = append(entries, db.auditEntry(tx, AuditActionUpdate, row.primaryKey, changedBefore, changedAfter))
+		}
+	}
+	db.storeAudit(tx, entries)
+}
+
+func (db *OrmDatabase) auditDelete(tx *gorm.DB) {
+	before, ok := db.auditedBefore(tx)
+	if !ok {
+		return
+	}
+	entries := make([]AuditEntry, 0, len(before))
+	for _, row := range before {
+		entries = append(entries, db.auditEntry(tx, AuditActionDelete, row.primaryKey, row.values, nil))
+	}
+	db.storeAudit(tx, entries)
+}
+
+func (db *OrmDatabase) auditedBefore(tx *gorm.DB) ([]auditRow, bool) {
+	if !db.auditing(tx) || tx.RowsAffected == 0 {
+		return nil, false
+	}
+	value, ok := tx.InstanceGet(auditBeforeKey)
+	if !ok {
+		return nil, false
+	}
+	rows, ok := value.([]auditRow)
+	return rows, ok && len(rows) > 0
+}
+
+func (db *OrmDatabase) auditEntry(tx *gorm.DB, action, primaryKey string, before, after map[string]interface{}) AuditEntry {
+	actor, _ := ActorFromContext(tx.Statement.Context)
+	return AuditEntry{
+		Table:      tx.Statement.Schema.Table,
+		PrimaryKey: primaryKey,
+		Action:     action,
+		Actor:      actor,
+		Before:     marshalAuditValues(before),
+		After:      marshalAuditValues(after),
+		CreatedAt:  time.Now(),
+	}
+}
+
+func (db *OrmDatabase) storeAudit(tx *gorm.DB, entries []AuditEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	if err := auditSession(tx).Create(&entries).Error; err != nil {
+		tx.AddError(fmt.Errorf("audit of %s: %w", tx.Statement.Table, err))
+	}
+}
+
+// auditSession is a fresh statement on the connection, and so the transaction, of tx
+func auditSession(tx *gorm.DB) *gorm.DB {
+	return tx.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Set(usePrimarySetting, true)
+}
+
+// auditQuery selects the rows an update or delete will touch, or returns nil when it has no conditions
+func auditQuery(tx *gorm.DB) *gorm.DB {
+	query := auditSession(tx)
+	where, hasWhere := tx.Statement.Clauses["WHERE"]
+	if hasWhere {
+		query = query.Clauses(where.Expression)
+	}
+
+	var keyed []auditRow
+	for _, row := range auditRows(tx.Statement, reflect.Indirect(tx.Statement.ReflectValue)) {
+		if row.key != nil {
+			keyed = append(keyed, row)
+		}
+	}
+	if len(keyed) > 0 {
+		query = query.Where(primaryKeyCondition(tx.Statement.Schema, keyed))
+	} else if !hasWhere && !tx.Statement.AllowGlobalUpdate {
+		return nil
+	}
+	return query
+}
+
+// primaryKeyCondition matches the rows with the primary keys of rows
+func primaryKeyCondition(s *schema.Schema, rows []auditRow) clause.Expression {
+	columns := make([]clause.Column, 0, len(s.PrimaryFields))
+	for _, field := range s.PrimaryFields {
+		columns = append(columns, clause.Column{Table: clause.CurrentTable, Name: field.DBName})
+	}
+	values := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		if len(columns) == 1 {
+			values = append(values, row.key[0])
+		} else {
+			values = append(values, row.key)
+		}
+	}
+	if len(columns) == 1 {
+		return clause.IN{Column: columns[0], Values: values}
+	}
+	return clause.IN{Column: columns, Values: values}
+}
+
+// loadAuditRows runs query against the unscoped table of statement, so soft deleted rows are included
+func loadAuditRows(statement *gorm.Statement, query *gorm.DB) ([]auditRow, error) {
+	rows := reflect.New(reflect.SliceOf(statement.Schema.ModelType))
+	if err := query.Unscoped().Table(statement.Table).Find(rows.Interface()).Error; err != nil {
+		return nil, err
+	}
+	return auditRows(statement, rows.Elem()), nil
+}
+
+// auditRows reads the columns of every model in value, a struct or a slice of structs
+func auditRows(statement *gorm.Statement, value reflect.Value) []auditRow {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		rows := make([]auditRow, 0, value.Len())
+		for i := 0; i < value.Len(); i++ {
+			rows = append(rows, auditRows(statement, reflect.Indirect(value.Index(i)))...)
+		}
+		return rows
+	case reflect.Struct:
+		if value.Type() != statement.Schema.ModelType {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	row := auditRow{values: make(map[string]interface{}, len(statement.Schema.DBNames))}
+	for _, column := range statement.Schema.DBNames {
+		field := statement.Schema.FieldsByDBName[column]
+		row.values[column], _ = field.ValueOf(statement.Context, value)
+	}
+	key := make([]interface{}, 0, len(statement.Schema.PrimaryFields))
+	for _, field := range statement.Schema.PrimaryFields {
+		fieldValue, zero := field.ValueOf(statement.Context, value)
+		if zero {
+			return []auditRow{row}
+		}
+		key = append(key, fieldValue)
+	}
+	row.key = key
+	row.primaryKey = joinPrimaryKey(key)
+	return []auditRow{row}
+}
+
+func joinPrimaryKey(values []interface{}) string {
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		parts = append(parts, fmt.Sprint(value))
+	}
+	return strings.Join(parts, ",")
+}
+
+// auditDiff returns the columns whose JSON representation differs between before and after
+func auditDiff(before, after map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	changedBefore := map[string]interface{}{}
+	changedAfter := map[string]interface{}{}
+	for column, value := range after {
+		old, _ := json.Marshal(before[column])
+		current, _ := json.Marshal(value)
+		if !bytes.Equal(old, current) {
+			changedBefore[column] = before[column]
+			changedAfter[column] = value
+		}
+	}
+	return changedBefore, changedAfter
+}
+
+func marshalAuditValues(values map[string]interface{}) json.RawMessage {
+	if values == nil {
+		return nil
+	}
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return nil
+	}
+	return raw
+}
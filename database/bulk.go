@@ -0,0 +1,351 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const defaultBatchSize = 500
+
+var ErrNoConflictColumns = errors.New("upsert needs conflict columns")
+
+var deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+
+// BulkResult counts the rows written by Upsert
+type BulkResult struct {
+	Inserted int64
+	Updated  int64
+}
+
+// UpsertOptions configures Upsert
+type UpsertOptions struct {
+	ConflictColumns []string // Unique key deciding between insert and update, Go or column names
+	UpdateColumns   []string // Overwritten on conflict; empty means every column but the keys, created_at, deleted_at and version
+	DoNothing       bool     // Skip conflicting rows instead of updating them
+	BatchSize       int      // Rows per statement, defaults to 500
+}
+
+// CreateInBatches inserts rows batchSize at a time (500 when zero) in a single transaction,
+// filling in their generated fields, and returns the number of rows inserted
+func CreateInBatches[T any](ctx context.Context, db *OrmDatabase, rows []T, batchSize int) (int64, error) {
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	result := db.Orm.WithContext(ctx).CreateInBatches(&rows, batchSizeOrDefault(batchSize))
+	return result.RowsAffected, result.Error
+}
+
+// Upsert inserts rows, updating the existing row instead when one has the same conflict columns.
+// Updating bumps the version of Versioned models, unless UpdateColumns sets it. Every batch runs in one transaction.
+// On Postgres the split between inserted and updated rows is exact; elsewhere rows are counted as updated
+// when their key existed beforehand, which is approximate under concurrent writers.
+func Upsert[T any](ctx context.Context, db *OrmDatabase, rows []T, opts UpsertOptions) (BulkResult, error) {
+	var result BulkResult
+	if len(rows) == 0 {
+		return result, nil
+	}
+	statement := &gorm.Statement{DB: db.Orm}
+	if err := statement.Parse(new(T)); err != nil {
+		return result, err
+	}
+
+	if len(opts.ConflictColumns) == 0 {
+		return result, ErrNoConflictColumns
+	}
+	conflict, err := bulkColumns(statement.Schema, opts.ConflictColumns)
+	if err != nil {
+		return result, err
+	}
+	tenantID, scoped, err := db.schemaTenant(ctx, statement.Schema)
+	if err != nil {
+		return result, err
+	}
+	if scoped {
+		// Otherwise a conflict could update the row of another tenant
+		if !containsColumn(conflict, TenantColumn) {
+			return result, fmt.Errorf("upsert on %s: conflict columns must include %s", statement.Schema.Table, TenantColumn)
+		}
+		// Stamp the tenant now, existing keys are counted before the create callbacks run
+		for i := range rows {
+			if err := stampTenant(ctx, statement.Schema, reflect.ValueOf(&rows[i]).Elem(), tenantID); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	onConflict := clause.OnConflict{DoNothing: opts.DoNothing}
+	for _, column := range conflict {
+		onConflict.Columns = append(onConflict.Columns, clause.Column{Name: column})
+	}
+	if !opts.DoNothing {
+		if len(opts.UpdateColumns) > 0 {
+			update, err := bulkColumns(statement.Schema, opts.UpdateColumns)
+			if err != nil {
+				return result, err
+			}
+			onConflict.DoUpdates = clause.AssignmentColumns(update)
+		} else {
+			onConflict.DoUpdates = clause.AssignmentColumns(upsertColumns(statement.Schema, conflict))
+		}
+		// Optimistic lockers must see the row changed
+		if field := statement.Schema.LookUpField(versionColumn); field != nil && !containsColumn(opts.UpdateColumns, versionColumn) {
+			column := clause.Column{Table: statement.Schema.Table, Name: field.DBName}
+			onConflict.DoUpdates = append(onConflict.DoUpdates, clause.Assignment{Column: clause.Column{Name: field.DBName}, Value: gorm.Expr("? + 1", column)})
+		}
+	}
+	// Only Postgres tells inserted rows from updated ones after the fact
+	exact := db.Orm.Dialector.Name() == DriverPostgres
+
+	size := batchSizeOrDefault(opts.BatchSize)
+	err = db.WithTransactionContext(ctx, func(tx *OrmDatabase) error {
+		var batchResult BulkResult
+		for start := 0; start < len(rows); start += size {
+			batch := rows[start:min(start+size, len(rows))]
+			existing := int64(0)
+			if !opts.DoNothing && !exact {
+				var err error
+				if existing, err = countRows[T](ctx, tx, statement.Schema, conflict, batch); err != nil {
+					return err
+				}
+			}
+
+			written := tx.Orm.WithContext(ctx).Clauses(onConflict).Create(&batch)
+			if written.Error != nil {
+				return written.Error
+			}
+			if opts.DoNothing {
+				batchResult.Inserted += written.RowsAffected
+				continue
+			}
+			if exact {
+				// Rows the batch inserted have no xmax while it is uncommitted, updated ones carry its lock, as in RETURNING (xmax = 0)
+				inserted, err := countRows[T](ctx, tx, statement.Schema, conflict, batch, clause.Expr{SQL: "xmax = 0"})
+				if err != nil {
+					return err
+				}
+				existing = int64(len(batch)) - inserted
+			}
+			batchResult.Inserted += int64(len(batch)) - existing
+			batchResult.Updated += existing
+		}
+		result = batchResult
+		return nil
+	})
+	return result, err
+}
+
+// CopyFrom loads rows with the Postgres COPY protocol and returns the number of rows copied.
+// columns defaults to every column not generated by the database. COPY bypasses gorm: hooks, the audit trail
+// and generated field write-back do not apply, timestamps and the tenant column are filled in here.
+// It runs on its own connection and so cannot join a transaction.
+func CopyFrom[T any](ctx context.Context, db *OrmDatabase, rows []T, columns ...string) (int64, error) {
+	if name := db.Orm.Dialector.Name(); name != DriverPostgres {
+		return 0, fmt.Errorf("COPY is not supported by %s", name)
+	}
+	if db.InTransaction() {
+		return 0, errors.New("COPY cannot run inside a transaction")
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	statement := &gorm.Statement{DB: db.Orm}
+	if err := statement.Parse(new(T)); err != nil {
+		return 0, err
+	}
+	fields, err := copyFields(statement.Schema, columns)
+	if err != nil {
+		return 0, err
+	}
+	names := make([]string, 0, len(fields)+1)
+	for _, field := range fields {
+		names = append(names, field.DBName)
+	}
+	if _, scoped, _ := db.schemaTenant(ctx, statement.Schema); scoped && !containsColumn(names, TenantColumn) {
+		fields = append(fields, statement.Schema.FieldsByDBName[TenantColumn])
+		names = append(names, TenantColumn)
+	}
+	values, err := db.copyValues(ctx, statement.Schema, fields, rows)
+	if err != nil {
+		return 0, err
+	}
+	pool, err := db.Orm.DB()
+	if err != nil {
+		return 0, err
+	}
+	conn, err := pool.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var copied int64
+	err = conn.Raw(func(driverConn interface{}) error {
+		stdlibConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		var err error
+		copied, err = stdlibConn.Conn().CopyFrom(ctx, pgx.Identifier{statement.Schema.Table}, names, pgx.CopyFromRows(values))
+		return err
+	})
+	if err != nil {
+		return copied, err
+	}
+	if db.EnableCaching {
+		if err := db.InvalidateCache(statement.Schema.Table); err != nil {
+			db.slog.Warn("Error invalidating query cache", "table", statement.Schema.Table, "err", err)
+		}
+	}
+	return copied, nil
+}
+
+// copyValues reads fields of every row, after stamping timestamps and the tenant as gorm would on create
+func (db *OrmDatabase) copyValues(ctx context.Context, s *schema.Schema, fields []*schema.Field, rows interface{}) ([][]interface{}, error) {
+	tenantID, scoped, err := db.schemaTenant(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	slice := reflect.ValueOf(rows)
+	values := make([][]interface{}, 0, slice.Len())
+	for i := 0; i < slice.Len(); i++ {
+		row := reflect.Indirect(slice.Index(i))
+		for _, field := range s.Fields {
+			if field.AutoCreateTime == 0 && field.AutoUpdateTime == 0 {
+				continue
+			}
+			if _, zero := field.ValueOf(ctx, row); zero {
+				if err := field.Set(ctx, row, now); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if scoped {
+			if err := stampTenant(ctx, s, row, tenantID); err != nil {
+				return nil, err
+			}
+		}
+
+		rowValues := make([]interface{}, 0, len(fields))
+		for _, field := range fields {
+			value, _ := field.ValueOf(ctx, row)
+			rowValues = append(rowValues, value)
+		}
+		values = append(values, rowValues)
+	}
+	return values, nil
+}
+
+// copyFields resolves columns, defaulting to every column not generated by the database
+func copyFields(s *schema.Schema, columns []string) ([]*schema.Field, error) {
+	if len(columns) == 0 {
+		generated := make(map[*schema.Field]bool, len(s.FieldsWithDefaultDBValue))
+		for _, field := range s.FieldsWithDefaultDBValue {
+			generated[field] = true
+		}
+		fields := make([]*schema.Field, 0, len(s.DBNames))
+		for _, column := range s.DBNames {
+			if field := s.FieldsByDBName[column]; !generated[field] {
+				fields = append(fields, field)
+			}
+		}
+		return fields, nil
+	}
+
+	names, err := bulkColumns(s, columns)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]*schema.Field, 0, len(names))
+	for _, column := range names {
+		fields = append(fields, s.FieldsByDBName[column])
+	}
+	return fields, nil
+}
+
+// countRows counts the rows holding the conflict columns of batch and matching conditions, soft deleted ones included
+func countRows[T any](ctx context.Context, db *OrmDatabase, s *schema.Schema, conflict []string, batch []T, conditions ...clause.Expression) (int64, error) {
+	keys := make([]interface{}, 0, len(batch))
+	for i := range batch {
+		row := reflect.ValueOf(&batch[i]).Elem()
+		key := make([]interface{}, 0, len(conflict))
+		for _, column := range conflict {
+			value, _ := s.FieldsByDBName[column].ValueOf(ctx, row)
+			key = append(key, value)
+		}
+		if len(key) == 1 {
+			keys = append(keys, key[0])
+		} else {
+			keys = append(keys, key)
+		}
+	}
+
+	columns := make([]clause.Column, 0, len(conflict))
+	for _, column := range conflict {
+		columns = append(columns, clause.Column{Table: clause.CurrentTable, Name: column})
+	}
+	condition := clause.IN{Column: columns, Values: keys}
+	if len(columns) == 1 {
+		condition.Column = columns[0]
+	}
+
+	var count int64
+	err := db.Orm.WithContext(ctx).Model(new(T)).Unscoped().Clauses(clause.Where{Exprs: append([]clause.Expression{condition}, conditions...)}).Count(&count).Error
+	return count, err
+}
+
+// upsertColumns lists the columns a conflicting row takes from the new one: every column written on insert
+// except the primary key, the conflict key, created_at, deleted_at and the version.
+func upsertColumns(s *schema.Schema, conflict []string) []string {
+	var columns []string
+	for _, field := range s.Fields {
+		if field.DBName == "" || !field.Creatable || field.PrimaryKey || field.AutoCreateTime > 0 ||
+			field.DBName == versionColumn || field.FieldType == deletedAtType || containsColumn(conflict, field.DBName) {
+			continue
+		}
+		// Left out of the insert when zero, like gorm's UpdateAll
+		if field.HasDefaultValue && field.DefaultValueInterface == nil && !strings.EqualFold(field.DefaultValue, "NULL") {
+			continue
+		}
+		columns = append(columns, field.DBName)
+	}
+	return columns
+}
+
+func bulkColumns(s *schema.Schema, fields []string) ([]string, error) {
+	columns := make([]string, 0, len(fields))
+	for _, field := range fields {
+		column, err := lookupColumn(s, field)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+func containsColumn(columns []string, column string) bool {
+	for _, candidate := range columns {
+		if candidate == column {
+			return true
+		}
+	}
+	return false
+}
+
+func batchSizeOrDefault(size int) int {
+	if size <= 0 {
+		return defaultBatchSize
+	}
+	return size
+}
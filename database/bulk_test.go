@@ -0,0 +1,64 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type upsertWidget struct {
+	gorm.Model
+	Versioned
+	Code string `gorm:"uniqueIndex"`
+	Name string
+}
+
+func TestUpsertKeepsVersionAndDeletion(t *testing.T) {
+	db := newCachedTestDatabase(t)
+	if err := db.Orm.AutoMigrate(&upsertWidget{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	opts := UpsertOptions{ConflictColumns: []string{"Code"}}
+
+	result, err := Upsert(ctx, db, []upsertWidget{{Code: "a", Name: "first"}, {Code: "b", Name: "first"}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (BulkResult{Inserted: 2}) {
+		t.Fatalf("first upsert = %+v, want 2 inserted", result)
+	}
+	var deleted upsertWidget
+	if err := db.Orm.Where("code = ?", "b").First(&deleted).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Orm.Delete(&deleted).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = Upsert(ctx, db, []upsertWidget{{Code: "a", Name: "second"}, {Code: "b", Name: "second"}, {Code: "c", Name: "second"}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (BulkResult{Inserted: 1, Updated: 2}) {
+		t.Fatalf("second upsert = %+v, want 1 inserted and 2 updated", result)
+	}
+
+	var rows []upsertWidget
+	if err := db.Orm.Unscoped().Order("code").Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if row.Name != "second" {
+			t.Errorf("%s: name = %q, want second", row.Code, row.Name)
+		}
+		wantVersion := map[string]uint64{"a": 2, "b": 2, "c": 1}[row.Code]
+		if row.Version != wantVersion {
+			t.Errorf("%s: version = %d, want %d", row.Code, row.Version, wantVersion)
+		}
+		if row.DeletedAt.Valid != (row.Code == "b") {
+			t.Errorf("%s: deleted = %v", row.Code, row.DeletedAt.Valid)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"context"
+)
+
+// ListenerPubSub is a caching.PubSub over Postgres NOTIFY, for services without Redis pub/sub.
+// listener must be running; payloads are limited to 8000 bytes.
+type ListenerPubSub struct {
+	db       *OrmDatabase
+	listener *Listener
+}
+
+func NewListenerPubSub(db *OrmDatabase, listener *Listener) *ListenerPubSub {
+	return &ListenerPubSub{db: db, listener: listener}
+}
+
+func (p *ListenerPubSub) Publish(ctx context.Context, channel, message string) error {
+	return p.db.Notify(ctx, channel, message)
+}
+
+// Subscribe returns the payloads notified on channel. The returned channel is also closed
+// when the listener stops running.
+func (p *ListenerPubSub) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	subscription := p.listener.Subscribe(channel)
+	messages := make(chan string)
+	go func() {
+		defer close(messages)
+		defer p.listener.Unsubscribe(channel, subscription)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case notification, ok := <-subscription:
+				if !ok {
+					return
+				}
+				select {
+				case messages <- notification.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return messages, nil
+}
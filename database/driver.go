@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"github.com/deveusss/evergram-core/config"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite" // In-process, pure Go; an empty Name opens a private in-memory database
+	DriverMySQL    = "mysql"
+)
+
+// Driver builds the connection string and the gorm dialector of one database engine
+type Driver struct {
+	BuildDSN      func(*config.DatabaseConfig) (DSN, error)
+	Dialector     func(DSN) gorm.Dialector
+	ConfigurePool func(*sql.DB, *config.DatabaseConfig) // Optional, runs after the generic pool settings
+}
+
+var (
+	driversMu sync.RWMutex
+	drivers   = map[string]Driver{
+		DriverPostgres: {
+			BuildDSN: func(dbConfig *config.DatabaseConfig) (DSN, error) {
+				return buildConnectionString(dbConfig), nil
+			},
+			Dialector: func(dsn DSN) gorm.Dialector { return postgres.Open(dsn.Reveal()) },
+		},
+		DriverSQLite: {
+			BuildDSN:      buildSQLiteConnectionString,
+			Dialector:     func(dsn DSN) gorm.Dialector { return sqlite.Open(dsn.Reveal()) },
+			ConfigurePool: configureSQLitePool,
+		},
+		DriverMySQL: {
+			BuildDSN:  buildMySQLConnectionString,
+			Dialector: func(dsn DSN) gorm.Dialector { return mysql.Open(dsn.Reveal()) },
+		},
+	}
+)
+
+// RegisterDriver makes a driver available under name to DatabaseConfig.Driver, replacing any previous one
+func RegisterDriver(name string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	drivers[name] = driver
+}
+
+// lookupDriver returns the driver registered under name, defaulting to Postgres
+func lookupDriver(name string) (Driver, error) {
+	if name == "" {
+		name = DriverPostgres
+	}
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	driver, ok := drivers[name]
+	if !ok {
+		return Driver{}, fmt.Errorf("unknown database driver %q", name)
+	}
+	return driver, nil
+}
+
+// openDialector builds the connection string and dialector for dbConfig
+func openDialector(dbConfig *config.DatabaseConfig) (DSN, gorm.Dialector, error) {
+	driver, err := lookupDriver(dbConfig.Driver)
+	if err != nil {
+		return DSN{}, nil, err
+	}
+	dsn, err := driver.BuildDSN(dbConfig)
+	if err != nil {
+		return DSN{}, nil, err
+	}
+	return dsn, driver.Dialector(dsn), nil
+}
+
+// configureSQLitePool keeps an in-memory database alive, as SQLite drops it with its last connection
+func configureSQLitePool(pool *sql.DB, dbConfig *config.DatabaseConfig) {
+	if dbConfig.Name != "" && dbConfig.Name != ":memory:" {
+		return
+	}
+	if dbConfig.MaxIdleConns < 1 {
+		pool.SetMaxIdleConns(1)
+	}
+	pool.SetConnMaxLifetime(0)
+	pool.SetConnMaxIdleTime(0)
+}
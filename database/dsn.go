@@ -0,0 +1,202 @@
+package database
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deveusss/evergram-core/common"
+	"github.com/deveusss/evergram-core/config"
+	"github.com/deveusss/evergram-core/encryption"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+)
+
+// DSN is a database connection string that redacts the password whenever it is printed or logged
+type DSN struct {
+	value    string
+	redacted string
+}
+
+// String implements fmt.Stringer with the password redacted
+func (d DSN) String() string {
+	return d.redacted
+}
+
+// GoString implements fmt.GoStringer with the password redacted
+func (d DSN) GoString() string {
+	return d.redacted
+}
+
+// LogValue implements slog.LogValuer with the password redacted
+func (d DSN) LogValue() slog.Value {
+	return slog.StringValue(d.redacted)
+}
+
+// Reveal returns the connection string including the password, to be handed to the driver only
+func (d DSN) Reveal() string {
+	return d.value
+}
+
+// buildConnectionString builds the database connection string for PostgreSQL
+func buildConnectionString(dbConfig *config.DatabaseConfig) DSN {
+	params := [][2]string{
+		{"host", dbConfig.Host},
+		{"port", strconv.Itoa(dbConfig.Port)},
+		{"user", dbConfig.User},
+		{"password", string(dbConfig.GetPassword().Get())},
+		{"dbname", dbConfig.Name},
+		{"sslmode", common.When[string](dbConfig.SSLMode == "").Then("disable").Else(dbConfig.SSLMode)},
+	}
+	optional := [][2]string{
+		{"sslrootcert", dbConfig.SSLRootCert},
+		{"sslcert", dbConfig.SSLCert},
+		{"sslkey", dbConfig.SSLKey},
+		{"application_name", dbConfig.ApplicationName},
+		{"search_path", dbConfig.SearchPath},
+	}
+	if dbConfig.ConnectTimeout > 0 {
+		// connect_timeout is in whole seconds, round up so sub-second timeouts are not disabled
+		seconds := (dbConfig.ConnectTimeout + time.Second - 1) / time.Second
+		optional = append(optional, [2]string{"connect_timeout", strconv.FormatInt(int64(seconds), 10)})
+	}
+	if dbConfig.StatementTimeout > 0 {
+		optional = append(optional, [2]string{"statement_timeout", strconv.FormatInt(dbConfig.StatementTimeout.Milliseconds(), 10)})
+	}
+	for _, param := range optional {
+		if param[1] != "" {
+			params = append(params, param)
+		}
+	}
+
+	pairs := make([]string, 0, len(params))
+	redacted := make([]string, 0, len(params))
+	for _, param := range params {
+		pairs = append(pairs, param[0]+"="+quoteConnectionValue(param[1]))
+		if param[0] == "password" {
+			redacted = append(redacted, param[0]+"="+encryption.Redacted)
+		} else {
+			redacted = append(redacted, pairs[len(pairs)-1])
+		}
+	}
+	return DSN{value: strings.Join(pairs, " "), redacted: strings.Join(redacted, " ")}
+}
+
+// quoteConnectionValue quotes a keyword/value connection string value when it is empty
+// or contains spaces, quotes or backslashes
+func quoteConnectionValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\n\r\f\v'\\") {
+		return value
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+	return "'" + escaped + "'"
+}
+
+// buildSQLiteConnectionString builds a SQLite URI for the file named by DatabaseConfig.Name.
+// An empty Name or ":memory:" yields a uniquely named in-memory database shared by the pool's connections.
+func buildSQLiteConnectionString(dbConfig *config.DatabaseConfig) (DSN, error) {
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	if dbConfig.ConnectTimeout > 0 {
+		params.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", dbConfig.ConnectTimeout.Milliseconds()))
+	}
+
+	name := dbConfig.Name
+	if name == "" || name == ":memory:" {
+		name = uuid.NewString()
+		params.Set("mode", "memory")
+		params.Set("cache", "shared")
+	}
+	dsn := "file:" + name + "?" + params.Encode()
+	return DSN{value: dsn, redacted: dsn}, nil
+}
+
+// buildMySQLConnectionString builds a go-sql-driver/mysql connection string
+func buildMySQLConnectionString(dbConfig *config.DatabaseConfig) (DSN, error) {
+	mysqlConfig := mysqldriver.NewConfig()
+	mysqlConfig.User = dbConfig.User
+	mysqlConfig.Passwd = string(dbConfig.GetPassword().Get())
+	mysqlConfig.Net = "tcp"
+	mysqlConfig.Addr = net.JoinHostPort(dbConfig.Host, strconv.Itoa(dbConfig.Port))
+	mysqlConfig.DBName = dbConfig.Name
+	mysqlConfig.ParseTime = true
+	mysqlConfig.MultiStatements = true // Migration scripts hold several statements
+	mysqlConfig.Timeout = dbConfig.ConnectTimeout
+	mysqlConfig.Params = map[string]string{"charset": "utf8mb4"}
+	if dbConfig.StatementTimeout > 0 {
+		mysqlConfig.Params["max_execution_time"] = strconv.FormatInt(dbConfig.StatementTimeout.Milliseconds(), 10)
+	}
+
+	tlsConfig, err := registerMySQLTLSConfig(dbConfig)
+	if err != nil {
+		return DSN{}, err
+	}
+	mysqlConfig.TLSConfig = tlsConfig
+
+	value := mysqlConfig.FormatDSN()
+	if mysqlConfig.Passwd != "" {
+		mysqlConfig.Passwd = encryption.Redacted
+	}
+	return DSN{value: value, redacted: mysqlConfig.FormatDSN()}, nil
+}
+
+// registerMySQLTLSConfig maps the Postgres style SSLMode and certificate paths onto a named MySQL TLS config
+func registerMySQLTLSConfig(dbConfig *config.DatabaseConfig) (string, error) {
+	switch dbConfig.SSLMode {
+	case "", "disable":
+		return "false", nil
+	case "require":
+		if dbConfig.SSLCert == "" {
+			return "skip-verify", nil
+		}
+	case "verify-ca", "verify-full":
+		if dbConfig.SSLRootCert == "" && dbConfig.SSLCert == "" {
+			return "true", nil
+		}
+	default:
+		return "", fmt.Errorf("unsupported ssl mode %q", dbConfig.SSLMode)
+	}
+
+	tlsConfig := &tls.Config{ServerName: dbConfig.Host, InsecureSkipVerify: dbConfig.SSLMode != "verify-full"}
+	if dbConfig.SSLCert != "" {
+		certificate, err := tls.LoadX509KeyPair(dbConfig.SSLCert, dbConfig.SSLKey)
+		if err != nil {
+			return "", err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	var roots *x509.CertPool // nil verifies against the system roots
+	if dbConfig.SSLRootCert != "" {
+		pem, err := os.ReadFile(dbConfig.SSLRootCert)
+		if err != nil {
+			return "", err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return "", fmt.Errorf("no certificates found in %s", dbConfig.SSLRootCert)
+		}
+		tlsConfig.RootCAs = roots
+	}
+	if dbConfig.SSLMode == "verify-ca" {
+		// Verify the chain but not the host name, like libpq's verify-ca
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			intermediates := x509.NewCertPool()
+			for _, certificate := range state.PeerCertificates[1:] {
+				intermediates.AddCert(certificate)
+			}
+			_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+			return err
+		}
+	}
+
+	name := "evergram-" + net.JoinHostPort(dbConfig.Host, strconv.Itoa(dbConfig.Port))
+	return name, mysqldriver.RegisterTLSConfig(name, tlsConfig)
+}
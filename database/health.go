@@ -0,0 +1,96 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	HealthStatusUp       = "up"
+	HealthStatusDegraded = "degraded" // The primary is reachable but some replicas are not
+	HealthStatusDown     = "down"
+
+	defaultHealthTimeout = 2 * time.Second
+)
+
+// Health is a point-in-time report of the database connectivity and pool usage
+type Health struct {
+	Status   string          `json:"status"`
+	Latency  time.Duration   `json:"latency_ns"`
+	Error    string          `json:"error,omitempty"`
+	Pool     PoolStats       `json:"pool"`
+	Replicas []ReplicaStatus `json:"replicas,omitempty"`
+}
+
+// PoolStats mirrors the relevant parts of sql.DBStats
+type PoolStats struct {
+	MaxOpen      int           `json:"max_open"`
+	Open         int           `json:"open"`
+	InUse        int           `json:"in_use"`
+	Idle         int           `json:"idle"`
+	WaitCount    int64         `json:"wait_count"`
+	WaitDuration time.Duration `json:"wait_duration_ns"`
+}
+
+// Health pings the primary without retrying and reports its latency, the pool statistics and the replica states
+func (db *OrmDatabase) Health(ctx context.Context) Health {
+	health := Health{Status: HealthStatusUp, Replicas: db.ReplicaStatuses()}
+
+	innerDb, err := db.Orm.DB()
+	if err != nil {
+		health.Status = HealthStatusDown
+		health.Error = err.Error()
+		return health
+	}
+
+	started := time.Now()
+	err = innerDb.PingContext(ctx)
+	health.Latency = time.Since(started)
+
+	stats := innerDb.Stats()
+	health.Pool = PoolStats{
+		MaxOpen:      stats.MaxOpenConnections,
+		Open:         stats.OpenConnections,
+		InUse:        stats.InUse,
+		Idle:         stats.Idle,
+		WaitCount:    stats.WaitCount,
+		WaitDuration: stats.WaitDuration,
+	}
+
+	if err != nil {
+		health.Status = HealthStatusDown
+		health.Error = err.Error()
+		return health
+	}
+	for _, replica := range health.Replicas {
+		if !replica.Healthy {
+			health.Status = HealthStatusDegraded
+			break
+		}
+	}
+	return health
+}
+
+// RegisterHealthRoutes serves the database health as JSON on /healthz and /readyz.
+// /healthz always answers 200 so a database outage doesn't restart the process,
+// /readyz answers 503 while the primary is unreachable.
+func RegisterHealthRoutes(router fiber.Router, db *OrmDatabase) {
+	router.Get("/healthz", healthHandler(db, false))
+	router.Get("/readyz", healthHandler(db, true))
+}
+
+func healthHandler(db *OrmDatabase, failWhenDown bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.UserContext(), defaultHealthTimeout)
+		defer cancel()
+
+		health := db.Health(ctx)
+		status := fiber.StatusOK
+		if failWhenDown && health.Status == HealthStatusDown {
+			status = fiber.StatusServiceUnavailable
+		}
+		return c.Status(status).JSON(health)
+	}
+}
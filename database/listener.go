@@ -0,0 +1,240 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+const (
+	defaultListenerBufferSize = 64
+	defaultListenerRetryWait  = time.Second
+)
+
+// Notification is a payload received on a LISTEN channel
+type Notification struct {
+	Channel string
+	Payload string
+	PID     uint32 // Backend process that sent it
+}
+
+// ListenerOptions tunes a Listener
+type ListenerOptions struct {
+	BufferSize int // Per subscription, defaults to 64. Notifications to a full subscription are dropped.
+}
+
+// Listener receives Postgres notifications on a dedicated connection of the OrmDatabase pool and fans them
+// out to Go channels. It reconnects and re-subscribes with the configured retry backoff after connection loss;
+// notifications sent while disconnected are lost.
+type Listener struct {
+	db      *OrmDatabase
+	options ListenerOptions
+
+	mu            sync.Mutex
+	subscriptions map[string][]chan Notification
+	wake          context.CancelFunc // Interrupts the wait so subscription changes are applied
+	closed        bool
+}
+
+func NewListener(db *OrmDatabase, options ListenerOptions) *Listener {
+	if options.BufferSize <= 0 {
+		options.BufferSize = defaultListenerBufferSize
+	}
+	return &Listener{db: db, options: options, subscriptions: map[string][]chan Notification{}}
+}
+
+// Notify sends payload to the listeners of channel. On a transaction-scoped OrmDatabase the notification is
+// delivered only if and when the transaction commits.
+func (db *OrmDatabase) Notify(ctx context.Context, channel, payload string) error {
+	return db.Orm.WithContext(ctx).Exec("SELECT pg_notify(?, ?)", channel, payload).Error
+}
+
+// Subscribe returns a channel receiving the notifications sent to channel.
+// It is closed by Unsubscribe or when Run returns.
+func (l *Listener) Subscribe(channel string) <-chan Notification {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	subscription := make(chan Notification, l.options.BufferSize)
+	if l.closed {
+		close(subscription)
+		return subscription
+	}
+	l.subscriptions[channel] = append(l.subscriptions[channel], subscription)
+	l.wakeLocked()
+	return subscription
+}
+
+// Unsubscribe stops and closes a subscription returned by Subscribe
+func (l *Listener) Unsubscribe(channel string, subscription <-chan Notification) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	subscriptions := l.subscriptions[channel]
+	for i, candidate := range subscriptions {
+		if candidate == subscription {
+			close(candidate)
+			subscriptions = append(subscriptions[:i], subscriptions[i+1:]...)
+			break
+		}
+	}
+	if len(subscriptions) == 0 {
+		delete(l.subscriptions, channel)
+		l.wakeLocked()
+	} else {
+		l.subscriptions[channel] = subscriptions
+	}
+}
+
+// Run listens until ctx is cancelled, then closes every subscription
+func (l *Listener) Run(ctx context.Context) error {
+	defer l.close()
+	if name := l.db.Orm.Dialector.Name(); name != DriverPostgres {
+		return fmt.Errorf("LISTEN is not supported by %s", name)
+	}
+	pool, err := l.db.Orm.DB()
+	if err != nil {
+		return err
+	}
+
+	backoff := l.db.retryBackoff()
+	failures := 0
+	for {
+		connected, err := l.listen(ctx, pool)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if connected {
+			failures = 0
+		}
+		wait := backoffWait(backoff, failures, defaultListenerRetryWait)
+		failures++
+		l.db.slog.Warn("Listener disconnected", "retry_in", wait, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// listen holds one connection until it fails or ctx is cancelled, reporting whether it got to listen
+func (l *Listener) listen(ctx context.Context, pool *sql.DB) (bool, error) {
+	conn, err := pool.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	connected := false
+	err = conn.Raw(func(driverConn interface{}) error {
+		stdlibConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		pgConn := stdlibConn.Conn()
+
+		listening := map[string]bool{}
+		for {
+			waitCtx, wake := context.WithCancel(ctx)
+			if err := l.sync(ctx, pgConn, listening, wake); err != nil {
+				wake()
+				return driver.ErrBadConn
+			}
+			connected = true
+
+			notification, err := pgConn.WaitForNotification(waitCtx)
+			wake()
+			if err != nil {
+				if ctx.Err() != nil {
+					// Leave the connection clean for the pool
+					if _, err := pgConn.Exec(context.Background(), "UNLISTEN *"); err != nil {
+						return driver.ErrBadConn
+					}
+					return nil
+				}
+				if waitCtx.Err() != nil {
+					continue
+				}
+				l.db.slog.Warn("Error waiting for notification", "err", err)
+				return driver.ErrBadConn
+			}
+			l.deliver(Notification{Channel: notification.Channel, Payload: notification.Payload, PID: notification.PID})
+		}
+	})
+	if errors.Is(err, driver.ErrBadConn) {
+		err = errors.New("listener connection lost")
+	}
+	return connected, err
+}
+
+// sync issues LISTEN and UNLISTEN so the connection follows the subscriptions, and arms wake for later changes
+func (l *Listener) sync(ctx context.Context, conn *pgx.Conn, listening map[string]bool, wake context.CancelFunc) error {
+	l.mu.Lock()
+	wanted := make([]string, 0, len(l.subscriptions))
+	for channel := range l.subscriptions {
+		wanted = append(wanted, channel)
+	}
+	l.wake = wake
+	l.mu.Unlock()
+
+	keep := make(map[string]bool, len(wanted))
+	for _, channel := range wanted {
+		keep[channel] = true
+		if listening[channel] {
+			continue
+		}
+		if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			l.db.slog.Error("Error listening", "channel", channel, "err", err)
+			return err
+		}
+		listening[channel] = true
+	}
+	for channel := range listening {
+		if keep[channel] {
+			continue
+		}
+		if _, err := conn.Exec(ctx, "UNLISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			l.db.slog.Error("Error unlistening", "channel", channel, "err", err)
+			return err
+		}
+		delete(listening, channel)
+	}
+	return nil
+}
+
+func (l *Listener) deliver(notification Notification) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, subscription := range l.subscriptions[notification.Channel] {
+		select {
+		case subscription <- notification:
+		default:
+			l.db.slog.Warn("Dropping notification for a full subscription", "channel", notification.Channel)
+		}
+	}
+}
+
+func (l *Listener) wakeLocked() {
+	if l.wake != nil {
+		l.wake()
+	}
+}
+
+func (l *Listener) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for channel, subscriptions := range l.subscriptions {
+		for _, subscription := range subscriptions {
+			close(subscription)
+		}
+		delete(l.subscriptions, channel)
+	}
+	l.closed = true
+}
@@ -0,0 +1,351 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/deveusss/evergram-core/common"
+	"github.com/deveusss/evergram-core/encryption"
+)
+
+const (
+	// MigrationsTable records the applied migration versions
+	MigrationsTable = "schema_migrations"
+	// migrationLockKey identifies the advisory lock held while migrating
+	migrationLockKey int64 = 7_245_381_920_117
+)
+
+var (
+	ErrNoMigrationsPath  = errors.New("migrations path is not configured")
+	ErrChecksumMismatch  = errors.New("applied migration has been modified")
+	ErrMissingDownScript = errors.New("migration has no down script")
+	ErrUnknownVersion    = errors.New("unknown migration version")
+
+	migrationFilePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+)
+
+// Migration is a numbered pair of up/down SQL scripts
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // SHA-256 of the up script
+}
+
+// MigrationStatus describes the state of a single migration
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+	Modified  bool // The up script changed after it was applied
+}
+
+// Migrator applies versioned SQL migrations read from a file system
+type Migrator struct {
+	db     *OrmDatabase
+	source fs.FS
+}
+
+// NewMigrator creates a Migrator reading files named <version>_<name>.up.sql and
+// <version>_<name>.down.sql from source, e.g. os.DirFS or an embed.FS sub-tree.
+func NewMigrator(db *OrmDatabase, source fs.FS) *Migrator {
+	return &Migrator{db: db, source: source}
+}
+
+// Migrator creates a Migrator reading from DatabaseConfig.MigrationsPath
+func (db *OrmDatabase) Migrator() (*Migrator, error) {
+	if db.config == nil || db.config.MigrationsPath == "" {
+		return nil, ErrNoMigrationsPath
+	}
+	return NewMigrator(db, os.DirFS(db.config.MigrationsPath)), nil
+}
+
+// Migrations loads and sorts every migration found in the source
+func (m *Migrator) Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(m.source, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+		script, err := fs.ReadFile(m.source, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d is used by both %q and %q", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(script)
+			migration.Checksum = encryption.Sha256Hash(migration.Up, "")
+		} else {
+			migration.Down = string(script)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies every pending migration
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.To(ctx, -1)
+}
+
+// To migrates up or down until version is the latest applied migration.
+// A negative version means the latest available migration.
+func (m *Migrator) To(ctx context.Context, version int64) error {
+	return m.locked(ctx, func(conn *sql.Conn, migrations []Migration, applied map[int64]appliedMigration) error {
+		if version < 0 && len(migrations) > 0 {
+			version = migrations[len(migrations)-1].Version
+		}
+		if version > 0 && !containsVersion(migrations, version) {
+			return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+		}
+
+		for i := len(migrations) - 1; i >= 0; i-- {
+			if _, ok := applied[migrations[i].Version]; ok && migrations[i].Version > version {
+				if err := m.down(ctx, conn, migrations[i]); err != nil {
+					return err
+				}
+			}
+		}
+		for _, migration := range migrations {
+			if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+				if err := m.up(ctx, conn, migration); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Rollback reverts the last steps applied migrations
+func (m *Migrator) Rollback(ctx context.Context, steps int) error {
+	return m.locked(ctx, func(conn *sql.Conn, migrations []Migration, applied map[int64]appliedMigration) error {
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			if _, ok := applied[migrations[i].Version]; !ok {
+				continue
+			}
+			if err := m.down(ctx, conn, migrations[i]); err != nil {
+				return err
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// Status reports every known migration and whether it has been applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := m.withConn(ctx, func(conn *sql.Conn) error {
+		migrations, err := m.Migrations()
+		if err != nil {
+			return err
+		}
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+			if record, ok := applied[migration.Version]; ok {
+				appliedAt := record.AppliedAt
+				status.Applied = true
+				status.AppliedAt = &appliedAt
+				status.Modified = record.Checksum != migration.Checksum
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+type appliedMigration struct {
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// locked runs fn on a dedicated connection holding the migration advisory lock,
+// after verifying that no applied migration has been modified.
+func (m *Migrator) locked(ctx context.Context, fn func(*sql.Conn, []Migration, map[int64]appliedMigration) error) error {
+	migrations, err := m.Migrations()
+	if err != nil {
+		return err
+	}
+
+	return m.withConn(ctx, func(conn *sql.Conn) error {
+		unlock, err := m.lock(ctx, conn)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			if record, ok := applied[migration.Version]; ok && record.Checksum != migration.Checksum {
+				return fmt.Errorf("%w: %d_%s", ErrChecksumMismatch, migration.Version, migration.Name)
+			}
+		}
+		return fn(conn, migrations, applied)
+	})
+}
+
+func (m *Migrator) withConn(ctx context.Context, fn func(*sql.Conn) error) error {
+	inner, err := m.db.Orm.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := inner.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	timestampType := common.When[string](m.dialect() == DriverPostgres).Then("TIMESTAMPTZ").Else("DATETIME")
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+MigrationsTable+` (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at `+timestampType+` NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, checksum, applied_at FROM "+MigrationsTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]appliedMigration)
+	for rows.Next() {
+		var version int64
+		var record appliedMigration
+		if err := rows.Scan(&version, &record.Checksum, &record.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = record
+	}
+	return applied, rows.Err()
+}
+
+func (m *Migrator) up(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	m.db.slog.Info("Applying migration", "version", migration.Version, "name", migration.Name)
+	return m.apply(ctx, conn, migration.Up,
+		"INSERT INTO "+MigrationsTable+" (version, name, checksum) VALUES ("+m.bindVar(1)+", "+m.bindVar(2)+", "+m.bindVar(3)+")",
+		migration.Version, migration.Name, migration.Checksum)
+}
+
+func (m *Migrator) down(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	if migration.Down == "" {
+		return fmt.Errorf("%w: %d_%s", ErrMissingDownScript, migration.Version, migration.Name)
+	}
+	m.db.slog.Info("Reverting migration", "version", migration.Version, "name", migration.Name)
+	return m.apply(ctx, conn, migration.Down,
+		"DELETE FROM "+MigrationsTable+" WHERE version = "+m.bindVar(1), migration.Version)
+}
+
+// apply runs script and the bookkeeping statement in a single transaction
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, script string, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// lock takes the migration lock on conn. Postgres uses an advisory lock and MySQL a named lock,
+// SQLite serialises writers on its own.
+func (m *Migrator) lock(ctx context.Context, conn *sql.Conn) (func(), error) {
+	var lock, unlock string
+	var key interface{}
+	switch m.dialect() {
+	case DriverPostgres:
+		lock, unlock, key = "SELECT pg_advisory_lock($1)", "SELECT pg_advisory_unlock($1)", migrationLockKey
+	case DriverMySQL:
+		lock, unlock, key = "SELECT GET_LOCK(?, -1)", "SELECT RELEASE_LOCK(?)", MigrationsTable
+	default:
+		return func() {}, nil
+	}
+
+	if _, err := conn.ExecContext(ctx, lock, key); err != nil {
+		return nil, err
+	}
+	return func() {
+		// Unlock with a fresh context so a cancelled ctx doesn't leave the lock held
+		if _, err := conn.ExecContext(context.Background(), unlock, key); err != nil {
+			m.db.slog.Error("Error releasing migration lock", "err", err)
+		}
+	}, nil
+}
+
+func (m *Migrator) dialect() string {
+	return m.db.Orm.Dialector.Name()
+}
+
+// bindVar returns the i-th (1-based) placeholder of the dialect
+func (m *Migrator) bindVar(i int) string {
+	if m.dialect() == DriverMySQL {
+		return "?"
+	}
+	return "$" + strconv.Itoa(i)
+}
+
+func containsVersion(migrations []Migration, version int64) bool {
+	for _, migration := range migrations {
+		if migration.Version == version {
+			return true
+		}
+	}
+	return false
+}
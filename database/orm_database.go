@@ -2,14 +2,13 @@ package database
 
 import (
 	"context"
-	"fmt"
+	"database/sql"
 	"log/slog"
 
 	"github.com/deveusss/evergram-core/caching"
 	"github.com/deveusss/evergram-core/config"
 
 	"github.com/eapache/go-resiliency/retrier"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
@@ -21,6 +20,11 @@ type OrmDatabase struct {
 	EnableCaching bool // Flag to enable or disable caching for queries
 	ctx           context.Context
 	slog          *slog.Logger
+	config        *config.DatabaseConfig
+	txDepth       int // Number of enclosing transactions and savepoints
+	replicas      *replicaSet
+	audit         *auditRegistry
+	invalidations *pendingInvalidations // Cache invalidations held back until their transaction commits
 }
 
 func New(log *slog.Logger, config *config.DatabaseConfig) (*OrmDatabase, error) {
@@ -29,89 +33,124 @@ func New(log *slog.Logger, config *config.DatabaseConfig) (*OrmDatabase, error)
 
 // NewDatabaseWithContext creates a new instance of OrmDatabase with context
 func NewWithContext(ctx context.Context, config *config.DatabaseConfig, enableCaching bool, slog *slog.Logger) (*OrmDatabase, error) {
-	dsn := buildConnectionString(config)
+	dsn, dialector, err := openDialector(config)
+	if err != nil {
+		slog.Error("Error building connection string", "err", err)
+		return nil, err
+	}
 	slog.Info("Connecting to database", "dsn", dsn)
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	retry := retrier.New(retrier.ExponentialBackoff(config.MaxRetries, config.RetryWait), TransientErrorClassifier{})
+	connectCtx := ctx
+	if connectCtx == nil {
+		connectCtx = context.Background()
+	}
+
+	queryLogger := NewQueryLogger(slog, queryLoggerOptions(config))
+	var db *gorm.DB
+	err = retry.RunCtx(connectCtx, func(context.Context) error {
+		var err error
+		db, err = gorm.Open(dialector, &gorm.Config{Logger: queryLogger})
+		if err != nil && db != nil {
+			// gorm keeps the pool open when the initial ping fails
+			if inner, innerErr := db.DB(); innerErr == nil {
+				_ = inner.Close()
+			}
+		}
+		return err
+	})
 	if err != nil {
-		slog.Error("Error opening database: %v", err)
+		slog.Error("Error opening database", "err", err)
 		return nil, err
 	}
 	inner, err := db.DB()
+	if err != nil {
+		slog.Error("Failed when getting inner DB", "err", err)
+		return nil, err
+	}
 
 	if ctx != nil {
 		// Check the connection during creation
-		if inner.PingContext(ctx); err != nil {
-			slog.Error("Error pinging database: %v", err)
+		if err := retry.RunCtx(ctx, inner.PingContext); err != nil {
+			slog.Error("Error pinging database", "err", err)
 			return nil, err
 		}
 	}
 
 	// Configure connection pool
-	inner.SetMaxOpenConns(config.MaxOpenConns)
-	inner.SetMaxIdleConns(config.MaxIdleConns)
+	configurePool(inner, config)
 
 	// Initialize cache
-	cache, err := caching.NewAppCache()
+	cache, err := caching.New(&config.Cache)
 	if err != nil {
-		slog.Error("Error initializing cache: %v", err)
+		slog.Error("Error initializing cache", "err", err)
 		return nil, err
 	}
 
-	retry := retrier.New(retrier.ExponentialBackoff(config.MaxRetries, config.RetryWait), nil)
+	odb := &OrmDatabase{ctx: ctx, Orm: db, Cache: cache, Retry: retry, EnableCaching: enableCaching, slog: slog, config: config,
+		audit: &auditRegistry{tables: map[string]bool{}}, invalidations: newPendingInvalidations()}
+	if err := odb.registerCacheCallbacks(); err != nil {
+		slog.Error("Error registering cache callbacks", "err", err)
+		return nil, err
+	}
+	if err := odb.registerTenantCallbacks(); err != nil {
+		slog.Error("Error registering tenant callbacks", "err", err)
+		return nil, err
+	}
+	if err := odb.registerAuditCallbacks(); err != nil {
+		slog.Error("Error registering audit callbacks", "err", err)
+		return nil, err
+	}
+	if len(config.Replicas) > 0 {
+		if err := odb.connectReplicas(config); err != nil {
+			slog.Error("Error connecting to replicas", "err", err)
+			return nil, err
+		}
+	}
 	slog.Info("Connected to", "dsn", dsn)
-	return &OrmDatabase{ctx: ctx, Orm: db, Cache: cache, Retry: retry, EnableCaching: enableCaching}, nil
+	return odb, nil
 }
 
 // OpenConnection opens a connection to the database
 func (db *OrmDatabase) OpenConnection() error {
 	innerDb, err := db.Orm.DB()
 	if err != nil {
-		db.slog.Error("Failed when getting inner DB: %v", err)
+		db.slog.Error("Failed when getting inner DB", "err", err)
 		return err
 	}
-	return innerDb.Ping()
+	return db.runRetryable(db.ctx, innerDb.PingContext)
 }
 
 // CloseConnection closes the connection to the database
 func (db *OrmDatabase) CloseConnection() error {
 	innerDb, err := db.Orm.DB()
 	if err != nil {
-		db.slog.Error("Failed when getting inner DB: %v", err)
+		db.slog.Error("Failed when getting inner DB", "err", err)
 		return err
 	}
+	if db.replicas != nil {
+		if err := db.replicas.close(); err != nil {
+			db.slog.Error("Error closing replicas", "err", err)
+		}
+	}
 	return innerDb.Close()
 }
 
-// WithTransactionWithContext executes a function inside a transaction with context
-func (db *OrmDatabase) WithTransactionContext(context context.Context, fn func(*OrmDatabase) error) error {
-	tx := db.Orm.WithContext(context).Begin()
-	if tx.Error != nil {
-		db.slog.Error("Error beginning transaction: %v", tx.Error)
-		return tx.Error
-	}
-
-	defer func() {
-		if p := recover(); p != nil {
-			_ = tx.Rollback()
-			db.slog.Error("Error in transaction: %v", p)
-		} else if err := recover(); err != nil {
-			_ = tx.Rollback()
-		} else {
-			err := tx.Commit().Error
-			if err != nil {
-				_ = tx.Rollback()
-				db.slog.Error("Error committing transaction: %v", err)
-			}
-		}
-	}()
-
-	return fn(&OrmDatabase{tx, db.Cache, db.Retry, db.EnableCaching, context, db.slog})
+// withOrm returns a copy of db that shares its cache, retrier and logger but runs on orm
+func (db *OrmDatabase) withOrm(orm *gorm.DB) *OrmDatabase {
+	clone := *db
+	clone.Orm = orm
+	return &clone
 }
 
-// buildConnectionString builds the database connection string for PostgreSQL
-func buildConnectionString(dbConfig *config.DatabaseConfig) string {
-	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		dbConfig.Host, dbConfig.Port, dbConfig.User, dbConfig.Password, dbConfig.Name)
+// configurePool applies the pool limits and connection lifetimes
+func configurePool(pool *sql.DB, dbConfig *config.DatabaseConfig) {
+	pool.SetMaxOpenConns(dbConfig.MaxOpenConns)
+	pool.SetMaxIdleConns(dbConfig.MaxIdleConns)
+	pool.SetConnMaxLifetime(dbConfig.ConnMaxLifetime)
+	pool.SetConnMaxIdleTime(dbConfig.ConnMaxIdleTime)
+	if driver, err := lookupDriver(dbConfig.Driver); err == nil && driver.ConfigurePool != nil {
+		driver.ConfigurePool(pool, dbConfig)
+	}
 }
 
 func (db *OrmDatabase) AuthMigrate(dst ...interface{}) error {
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultOutboxPollInterval = time.Second
+	defaultOutboxBatchSize    = 100
+	defaultOutboxRetryWait    = time.Second
+)
+
+// OutboxMessage is an event stored in the outbox table until a relay delivers it.
+// Create the table with AuthMigrate(&OutboxMessage{}).
+type OutboxMessage struct {
+	ID            uint64          `gorm:"primaryKey"`
+	Topic         string          `gorm:"not null;index"`
+	Key           string          // Partitioning or deduplication key, passed through to the publisher
+	Payload       json.RawMessage `gorm:"not null"`
+	CreatedAt     time.Time       `gorm:"not null"`
+	NextAttemptAt time.Time       `gorm:"not null;index"`
+	DeliveredAt   *time.Time      `gorm:"index"`
+	Attempts      int             `gorm:"not null;default:0"`
+	LastError     string
+}
+
+func (OutboxMessage) TableName() string {
+	return "outbox"
+}
+
+// Publisher delivers outbox messages to a broker. Delivery is at-least-once,
+// consumers can deduplicate on the message ID.
+type Publisher interface {
+	Publish(ctx context.Context, message OutboxMessage) error
+}
+
+// EnqueueMessage writes a message to the outbox. Call it on the transaction-scoped OrmDatabase given by
+// WithTransactionContext so the message is stored if and only if the surrounding writes commit.
+func (db *OrmDatabase) EnqueueMessage(ctx context.Context, topic, key string, payload interface{}) error {
+	raw, ok := payload.([]byte)
+	if !ok {
+		var err error
+		raw, err = json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	message := &OutboxMessage{Topic: topic, Key: key, Payload: raw, CreatedAt: now, NextAttemptAt: now}
+	return db.Orm.WithContext(ctx).Create(message).Error
+}
+
+// OutboxRelayOptions tunes an OutboxRelay
+type OutboxRelayOptions struct {
+	PollInterval time.Duration // Defaults to one second
+	BatchSize    int           // Defaults to 100
+}
+
+// OutboxRelay polls the outbox and hands undelivered messages to a Publisher.
+// Several relays may run against the same table; rows are claimed with FOR UPDATE SKIP LOCKED.
+type OutboxRelay struct {
+	db        *OrmDatabase
+	publisher Publisher
+	options   OutboxRelayOptions
+	backoff   []time.Duration
+}
+
+func NewOutboxRelay(db *OrmDatabase, publisher Publisher, options OutboxRelayOptions) *OutboxRelay {
+	if options.PollInterval <= 0 {
+		options.PollInterval = defaultOutboxPollInterval
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultOutboxBatchSize
+	}
+	return &OutboxRelay{db: db, publisher: publisher, options: options, backoff: db.retryBackoff()}
+}
+
+// Run relays messages until ctx is cancelled
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.options.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Drain full batches straight away, wait for the next tick otherwise
+		delivered, err := r.RelayBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.db.slog.Error("Error relaying outbox", "err", err)
+		}
+		if err == nil && delivered == r.options.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayBatch claims up to BatchSize due messages, publishes them and records the outcome.
+// It returns the number of messages claimed.
+func (r *OutboxRelay) RelayBatch(ctx context.Context) (int, error) {
+	claimed := 0
+	err := r.db.WithTransactionContext(ctx, func(tx *OrmDatabase) error {
+		var messages []OutboxMessage
+		err := tx.Orm.WithContext(ctx).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("delivered_at IS NULL AND next_attempt_at <= ?", time.Now()).
+			Order("id").
+			Limit(r.options.BatchSize).
+			Find(&messages).Error
+		if err != nil {
+			return err
+		}
+		claimed = len(messages)
+
+		for _, message := range messages {
+			if err := r.deliver(ctx, tx.Orm.WithContext(ctx), message); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return claimed, err
+}
+
+// deliver publishes message and marks it delivered, or schedules its next attempt
+func (r *OutboxRelay) deliver(ctx context.Context, tx *gorm.DB, message OutboxMessage) error {
+	publishErr := r.publisher.Publish(ctx, message)
+	now := time.Now()
+	if publishErr == nil {
+		return tx.Model(&message).Updates(map[string]interface{}{
+			"delivered_at": now,
+			"attempts":     message.Attempts + 1,
+			"last_error":   "",
+		}).Error
+	}
+
+	wait := r.retryWait(message.Attempts)
+	r.db.slog.Warn("Error publishing outbox message", "id", message.ID, "topic", message.Topic,
+		"attempt", message.Attempts+1, "retry_in", wait, "err", publishErr)
+	return tx.Model(&message).Updates(map[string]interface{}{
+		"attempts":        message.Attempts + 1,
+		"last_error":      publishErr.Error(),
+		"next_attempt_at": now.Add(wait),
+	}).Error
+}
+
+// retryWait follows the configured exponential backoff, messages are never dropped
+func (r *OutboxRelay) retryWait(attempts int) time.Duration {
+	return backoffWait(r.backoff, attempts, defaultOutboxRetryWait)
+}
+
+// InMemoryPublisher records published messages, for tests
+type InMemoryPublisher struct {
+	mu       sync.Mutex
+	messages []OutboxMessage
+	// Fail, when set, is consulted before recording a message and its error is returned instead
+	Fail func(OutboxMessage) error
+}
+
+func NewInMemoryPublisher() *InMemoryPublisher {
+	return &InMemoryPublisher{}
+}
+
+// Publish implements Publisher
+func (p *InMemoryPublisher) Publish(_ context.Context, message OutboxMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Fail != nil {
+		if err := p.Fail(message); err != nil {
+			return err
+		}
+	}
+	p.messages = append(p.messages, message)
+	return nil
+}
+
+// Messages returns a copy of the messages published so far
+func (p *InMemoryPublisher) Messages() []OutboxMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]OutboxMessage(nil), p.messages...)
+}
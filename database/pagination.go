@@ -0,0 +1,217 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/deveusss/evergram-core/common"
+	"github.com/deveusss/evergram-core/config"
+	"github.com/deveusss/evergram-core/encryption"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	KeysetCreatedColumn = "created_at"
+	KeysetIDColumn      = "id"
+
+	pageTokenKeyLabel = "evergram:page-token"
+	defaultPageLimit  = 20
+	maxPageLimit      = 500
+)
+
+var (
+	ErrInvalidPageToken = errors.New("invalid page token")
+	ErrNoPageSecret     = errors.New("page tokens need a JWT secret")
+)
+
+// PageRequest asks for one page of a listing ordered by (created_at, id)
+type PageRequest struct {
+	Token string // Empty for the first page
+	Limit int    // Defaults to 20, capped at 500
+	Desc  bool   // Newest first
+	// Scope names the query, so a token cannot be replayed against another listing of the same table
+	Scope string
+}
+
+// Paginator issues and verifies opaque page tokens signed with a key derived from the JWT secret
+type Paginator struct {
+	key encryption.ISecureString
+}
+
+// pageCursor is the position after the last row of a page
+type pageCursor struct {
+	CreatedAt time.Time   `json:"c"`
+	ID        interface{} `json:"i"`
+	Desc      bool        `json:"d"`
+	Scope     string      `json:"s"` // Table and query the token was issued for
+}
+
+// NewPaginator fails without a JWT secret, which would let anyone forge tokens
+func NewPaginator(auth *config.AuthConfig) (*Paginator, error) {
+	if auth.Jwt.Secret == "" {
+		return nil, ErrNoPageSecret
+	}
+	// A key of its own, so page tokens and JWTs cannot be signed for one another
+	mac := hmac.New(sha256.New, auth.Jwt.GetSecret().Get())
+	mac.Write([]byte(pageTokenKeyLabel))
+	return &Paginator{key: encryption.NewSecureString(string(mac.Sum(nil)))}, nil
+}
+
+// Paginate returns the page of T selected by query that follows req.Token,
+// using WHERE (created_at, id) > (...) instead of an offset. Tokens are only valid for the table of T and req.Scope.
+func Paginate[T any](ctx context.Context, db *OrmDatabase, paginator *Paginator, req PageRequest, query func(*gorm.DB) *gorm.DB) (*common.Page[T], error) {
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultPageLimit
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+
+	table, err := tableOf[T](db)
+	if err != nil {
+		return nil, err
+	}
+	scope := table + ":" + req.Scope
+
+	var cursor *pageCursor
+	if req.Token != "" {
+		decoded, err := paginator.decode(req.Token)
+		if err != nil {
+			return nil, err
+		}
+		if decoded.Desc != req.Desc {
+			return nil, fmt.Errorf("%w: sort direction changed", ErrInvalidPageToken)
+		}
+		if decoded.Scope != scope {
+			return nil, fmt.Errorf("%w: issued for another query", ErrInvalidPageToken)
+		}
+		cursor = decoded
+	}
+
+	created := clause.Column{Table: clause.CurrentTable, Name: KeysetCreatedColumn}
+	id := clause.Column{Table: clause.CurrentTable, Name: KeysetIDColumn}
+	comparison := common.When[string](req.Desc).Then("<").Else(">")
+
+	// Fetch one extra row to learn whether another page follows
+	items, err := FindCached[T](ctx, db, func(tx *gorm.DB) *gorm.DB {
+		if query != nil {
+			tx = query(tx)
+		}
+		if cursor != nil {
+			tx = tx.Where("(?, ?) "+comparison+" (?, ?)", created, id, cursor.CreatedAt, cursor.ID)
+		}
+		return tx.Order(clause.OrderByColumn{Column: created, Desc: req.Desc}).
+			Order(clause.OrderByColumn{Column: id, Desc: req.Desc}).
+			Limit(limit + 1)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hasMore := len(items) > limit
+	if !hasMore {
+		return common.NewPage(items, "", false), nil
+	}
+	items = items[:limit]
+
+	next, err := keysetCursor(db, &items[limit-1])
+	if err != nil {
+		return nil, err
+	}
+	next.Desc = req.Desc
+	next.Scope = scope
+	token, err := paginator.encode(next)
+	if err != nil {
+		return nil, err
+	}
+	return common.NewPage(items, token, true), nil
+}
+
+func tableOf[T any](db *OrmDatabase) (string, error) {
+	statement := &gorm.Statement{DB: db.Orm}
+	if err := statement.Parse(new(T)); err != nil {
+		return "", err
+	}
+	return statement.Schema.Table, nil
+}
+
+// keysetCursor reads the created_at and id fields of item
+func keysetCursor[T any](db *OrmDatabase, item *T) (*pageCursor, error) {
+	statement := &gorm.Statement{DB: db.Orm}
+	if err := statement.Parse(item); err != nil {
+		return nil, err
+	}
+	createdField := statement.Schema.LookUpField(KeysetCreatedColumn)
+	idField := statement.Schema.LookUpField(KeysetIDColumn)
+	if createdField == nil || idField == nil {
+		return nil, fmt.Errorf("%s has no %s and %s columns to paginate on", statement.Schema.Name, KeysetCreatedColumn, KeysetIDColumn)
+	}
+
+	value := reflect.ValueOf(item).Elem()
+	createdValue, _ := createdField.ValueOf(context.Background(), value)
+	idValue, _ := idField.ValueOf(context.Background(), value)
+	createdAt, ok := createdValue.(time.Time)
+	if !ok {
+		return nil, fmt.Errorf("%s.%s is not a time.Time", statement.Schema.Name, KeysetCreatedColumn)
+	}
+	return &pageCursor{CreatedAt: createdAt, ID: idValue}, nil
+}
+
+// encode serialises the cursor as base64(payload).base64(HMAC-SHA256(payload))
+func (p *Paginator) encode(cursor *pageCursor) (string, error) {
+	payload, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	encoding := base64.RawURLEncoding
+	return encoding.EncodeToString(payload) + "." + encoding.EncodeToString(p.sign(payload)), nil
+}
+
+func (p *Paginator) decode(token string) (*pageCursor, error) {
+	encodedPayload, encodedSignature, found := strings.Cut(token, ".")
+	if !found {
+		return nil, ErrInvalidPageToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, p.sign(payload)) {
+		return nil, ErrInvalidPageToken
+	}
+
+	var cursor pageCursor
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&cursor); err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	// Keep integer keys integral instead of float64
+	if number, ok := cursor.ID.(json.Number); ok {
+		if id, err := number.Int64(); err == nil {
+			cursor.ID = id
+		} else {
+			cursor.ID = number.String()
+		}
+	}
+	return &cursor, nil
+}
+
+func (p *Paginator) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, p.key.Get())
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
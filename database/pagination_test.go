@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deveusss/evergram-core/config"
+)
+
+type pagedWidget struct {
+	ID        uint
+	Name      string
+	CreatedAt time.Time
+}
+
+func TestNewPaginatorNeedsSecret(t *testing.T) {
+	if _, err := NewPaginator(&config.AuthConfig{}); !errors.Is(err, ErrNoPageSecret) {
+		t.Fatalf("err = %v, want %v", err, ErrNoPageSecret)
+	}
+}
+
+func TestPaginateRejectsTokenOfAnotherScope(t *testing.T) {
+	db := newCachedTestDatabase(t)
+	if err := db.Orm.AutoMigrate(&pagedWidget{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := db.Orm.Create(&pagedWidget{Name: name}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	auth := &config.AuthConfig{}
+	auth.Jwt.Secret = "secret"
+	paginator, err := NewPaginator(auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	first, err := Paginate[pagedWidget](ctx, db, paginator, PageRequest{Limit: 2, Scope: "all"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Result) != 2 || !first.HasMore {
+		t.Fatalf("first page = %d items, more %v; want 2, true", len(first.Result), first.HasMore)
+	}
+
+	next, err := Paginate[pagedWidget](ctx, db, paginator, PageRequest{Token: first.NextPageToken, Limit: 2, Scope: "all"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(next.Result) != 1 || next.Result[0].Name != "c" {
+		t.Fatalf("second page = %+v, want [c]", next.Result)
+	}
+
+	_, err = Paginate[pagedWidget](ctx, db, paginator, PageRequest{Token: first.NextPageToken, Limit: 2, Scope: "other"}, nil)
+	if !errors.Is(err, ErrInvalidPageToken) {
+		t.Fatalf("err for another scope = %v, want %v", err, ErrInvalidPageToken)
+	}
+}
+
+func TestPageTokensNotSignedWithJwtSecret(t *testing.T) {
+	auth := &config.AuthConfig{}
+	auth.Jwt.Secret = "secret"
+	paginator, err := NewPaginator(auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := paginator.encode(&pageCursor{ID: int64(1), Scope: "widgets:all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encodedPayload, _, _ := strings.Cut(token, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte(auth.Jwt.Secret))
+	mac.Write(payload)
+	forged := encodedPayload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	if forged == token {
+		t.Fatal("page token signed with the raw JWT secret")
+	}
+	if _, err := paginator.decode(forged); !errors.Is(err, ErrInvalidPageToken) {
+		t.Fatalf("decode of a token signed with the JWT secret err = %v, want %v", err, ErrInvalidPageToken)
+	}
+	if _, err := paginator.decode(token); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,215 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/deveusss/evergram-core/caching"
+	"github.com/deveusss/evergram-core/encryption"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	queryCachePrefix      = "orm:query:"
+	queryGenerationPrefix = "orm:generation:"
+	cacheCallbackName     = "evergram:cache_invalidate"
+)
+
+// FindCached runs query against the table of T and returns all matching rows.
+// When caching is enabled the result is served from and stored in db.Cache for CacheTTL,
+// keyed on the generated SQL and its arguments. Only writes to the table of T invalidate the result,
+// so queries using Joins or Preload are never cached. Results are cached only when read from the primary,
+// rows from a lagging replica would otherwise outlive the invalidation of their table.
+func FindCached[T any](ctx context.Context, db *OrmDatabase, query func(*gorm.DB) *gorm.DB) ([]T, error) {
+	return cachedQuery[T](ctx, db, query, func(tx *gorm.DB, dest *[]T) *gorm.DB {
+		return tx.Find(dest)
+	})
+}
+
+// FirstCached runs query against the table of T and returns the first row ordered by primary key.
+// gorm.ErrRecordNotFound is returned, and never cached, when nothing matches.
+func FirstCached[T any](ctx context.Context, db *OrmDatabase, query func(*gorm.DB) *gorm.DB) (*T, error) {
+	return cachedQuery[T](ctx, db, query, func(tx *gorm.DB, dest **T) *gorm.DB {
+		*dest = new(T)
+		return tx.First(*dest)
+	})
+}
+
+// InvalidateCache drops every cached query result for the given tables.
+// Writes made through gorm are invalidated automatically; this is meant for raw Exec statements.
+// Inside a transaction the tables are invalidated once it commits, and not at all if it rolls back.
+func (db *OrmDatabase) InvalidateCache(tables ...string) error {
+	if db.Cache == nil {
+		return nil
+	}
+	if db.invalidations.queue(db.Orm.Statement.ConnPool, tables) {
+		return nil
+	}
+	for _, table := range tables {
+		if _, err := db.bumpTableGeneration(table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cachedQuery[T, R any](ctx context.Context, db *OrmDatabase, query func(*gorm.DB) *gorm.DB, finish func(*gorm.DB, *R) *gorm.DB) (R, error) {
+	scoped := func(ctx context.Context, tx *gorm.DB, dest *R) *gorm.DB {
+		tx = tx.WithContext(ctx).Model(new(T))
+		if query != nil {
+			tx = query(tx)
+		}
+		return finish(tx, dest)
+	}
+	// Reads are idempotent, so transient failures are retried
+	load := func(ctx context.Context, tx *gorm.DB, dest *R) error {
+		return db.runRetryable(ctx, func(ctx context.Context) error {
+			return scoped(ctx, tx, dest).Error
+		})
+	}
+
+	var result R
+	if !db.cachingActive() {
+		return result, load(ctx, db.Orm, &result)
+	}
+
+	// Render the statement without executing or logging it to derive the cache key
+	dry := scoped(ctx, db.Orm.Session(&gorm.Session{DryRun: true, SkipDefaultTransaction: true, Logger: logger.Discard}), new(R))
+	if dry.Error != nil {
+		return result, dry.Error
+	}
+	if len(dry.Statement.Joins) > 0 || len(dry.Statement.Preloads) > 0 {
+		// Writes to the other tables would not invalidate the result
+		return result, load(ctx, db.Orm, &result)
+	}
+	sql := db.Orm.Dialector.Explain(dry.Statement.SQL.String(), dry.Statement.Vars...)
+	key, err := db.queryCacheKey(dry.Statement.Table, sql)
+	if err != nil {
+		db.slog.Warn("Error reading cache generation", "table", dry.Statement.Table, "err", err)
+		return result, load(ctx, db.Orm, &result)
+	}
+
+	// Concurrent misses of one query share a single load
+	primary := db.UsePrimary().Orm
+	result, err = caching.GetOrLoadAs[R](ctx, db.Cache, key, db.cacheTTL(), func(ctx context.Context) (R, error) {
+		var loaded R
+		return loaded, load(ctx, primary, &loaded)
+	})
+	if errors.Is(err, caching.ErrDecode) {
+		db.slog.Warn("Error decoding cached query result", "table", dry.Statement.Table, "err", err)
+		return result, load(ctx, db.Orm, &result)
+	}
+	return result, err
+}
+
+// cachingActive reports whether reads should go through the cache.
+// Reads inside a transaction bypass it, since they may observe uncommitted writes.
+func (db *OrmDatabase) cachingActive() bool {
+	return db.EnableCaching && db.Cache != nil && !db.InTransaction()
+}
+
+func (db *OrmDatabase) cacheTTL() time.Duration {
+	if db.config == nil {
+		return 0
+	}
+	return db.config.CacheTTL
+}
+
+// queryCacheKey scopes the hashed SQL to the current generation of table,
+// so bumping the generation orphans every entry cached for it.
+func (db *OrmDatabase) queryCacheKey(table, sql string) (string, error) {
+	generation, err := db.tableGeneration(table)
+	if err != nil {
+		return "", err
+	}
+	return queryCachePrefix + table + ":" + generation + ":" + encryption.Sha256Hash(sql, table), nil
+}
+
+func (db *OrmDatabase) tableGeneration(table string) (string, error) {
+	if value, ok := db.Cache.Get(queryGenerationPrefix + table); ok {
+		if raw, ok := value.([]byte); ok {
+			return string(raw), nil
+		}
+	}
+	return db.bumpTableGeneration(table)
+}
+
+func (db *OrmDatabase) bumpTableGeneration(table string) (string, error) {
+	generation := strconv.FormatInt(time.Now().UnixNano(), 10)
+	return generation, db.Cache.Set(queryGenerationPrefix+table, []byte(generation))
+}
+
+// pendingInvalidations holds the tables written by each open transaction, keyed by its connection.
+// Bumping their generations before commit would let readers cache the old rows under the new generation.
+type pendingInvalidations struct {
+	mu     sync.Mutex
+	tables map[gorm.ConnPool]map[string]bool
+}
+
+func newPendingInvalidations() *pendingInvalidations {
+	return &pendingInvalidations{tables: map[gorm.ConnPool]map[string]bool{}}
+}
+
+func (p *pendingInvalidations) begin(tx gorm.ConnPool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tables[tx] = map[string]bool{}
+}
+
+// queue records tables for the transaction running on conn, reporting false when there is none
+func (p *pendingInvalidations) queue(conn gorm.ConnPool, tables []string) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pending, ok := p.tables[conn]
+	if !ok {
+		return false
+	}
+	for _, table := range tables {
+		pending[table] = true
+	}
+	return true
+}
+
+// end forgets the transaction on tx and returns the tables it wrote
+func (p *pendingInvalidations) end(tx gorm.ConnPool) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tables := make([]string, 0, len(p.tables[tx]))
+	for table := range p.tables[tx] {
+		tables = append(tables, table)
+	}
+	delete(p.tables, tx)
+	return tables
+}
+
+// registerCacheCallbacks invalidates the cached results of a table after every create, update and delete on it
+// is committed. Writes in an explicit transaction are queued until WithTransactionOptions commits it.
+func (db *OrmDatabase) registerCacheCallbacks() error {
+	invalidate := func(tx *gorm.DB) {
+		if tx.Error != nil || tx.DryRun || tx.Statement.Table == "" || !db.EnableCaching {
+			return
+		}
+		// The pool is back to the explicit transaction, if any, once gorm has committed its own
+		if err := db.withOrm(tx).InvalidateCache(tx.Statement.Table); err != nil {
+			db.slog.Warn("Error invalidating query cache", "table", tx.Statement.Table, "err", err)
+		}
+	}
+
+	callbacks := db.Orm.Callback()
+	const committed = "gorm:commit_or_rollback_transaction"
+	if err := callbacks.Create().After(committed).Register(cacheCallbackName, invalidate); err != nil {
+		return err
+	}
+	if err := callbacks.Update().After(committed).Register(cacheCallbackName, invalidate); err != nil {
+		return err
+	}
+	return callbacks.Delete().After(committed).Register(cacheCallbackName, invalidate)
+}
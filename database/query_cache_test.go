@@ -0,0 +1,134 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/deveusss/evergram-core/config"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+type cachedWidget struct {
+	ID   uint
+	Name string
+}
+
+func newCachedTestDatabase(t *testing.T) *OrmDatabase {
+	t.Helper()
+	// A file rather than shared memory, so reads outside a transaction are not blocked by its writes
+	dbConfig := &config.DatabaseConfig{Driver: DriverSQLite, Name: filepath.Join(t.TempDir(), "cache.db"), MaxRetries: 1, MaxOpenConns: 4}
+	db, err := NewWithContext(context.Background(), dbConfig, true, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.CloseConnection() })
+	if err := db.Orm.AutoMigrate(&cachedWidget{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Orm.Create(&cachedWidget{Name: "first"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func countCachedWidgets(t *testing.T, db *OrmDatabase) int {
+	t.Helper()
+	rows, err := FindCached[cachedWidget](context.Background(), db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(rows)
+}
+
+func TestFindCachedInvalidatedAfterCommit(t *testing.T) {
+	db := newCachedTestDatabase(t)
+	ctx := context.Background()
+
+	err := db.WithTransactionContext(ctx, func(tx *OrmDatabase) error {
+		if err := tx.Orm.Create(&cachedWidget{Name: "second"}).Error; err != nil {
+			return err
+		}
+		// Caches the committed rows while the insert is pending
+		if got := countCachedWidgets(t, db); got != 1 {
+			t.Errorf("rows read outside the transaction = %d, want 1", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := countCachedWidgets(t, db); got != 2 {
+		t.Fatalf("rows after commit = %d, want 2", got)
+	}
+}
+
+func TestFindCachedKeptAfterRollback(t *testing.T) {
+	db := newCachedTestDatabase(t)
+	ctx := context.Background()
+	if got := countCachedWidgets(t, db); got != 1 {
+		t.Fatalf("rows = %d, want 1", got)
+	}
+
+	err := db.WithTransactionContext(ctx, func(tx *OrmDatabase) error {
+		if err := tx.Orm.Create(&cachedWidget{Name: "second"}).Error; err != nil {
+			return err
+		}
+		return gorm.ErrInvalidData
+	})
+	if err != gorm.ErrInvalidData {
+		t.Fatalf("err = %v, want %v", err, gorm.ErrInvalidData)
+	}
+	if pending := len(db.invalidations.tables); pending != 0 {
+		t.Fatalf("transactions with pending invalidations = %d, want 0", pending)
+	}
+	if got := countCachedWidgets(t, db); got != 1 {
+		t.Fatalf("rows after rollback = %d, want 1", got)
+	}
+}
+
+func TestFindCachedInvalidatedByAutocommitWrite(t *testing.T) {
+	db := newCachedTestDatabase(t)
+	if got := countCachedWidgets(t, db); got != 1 {
+		t.Fatalf("rows = %d, want 1", got)
+	}
+	if err := db.Orm.Create(&cachedWidget{Name: "second"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got := countCachedWidgets(t, db); got != 2 {
+		t.Fatalf("rows after insert = %d, want 2", got)
+	}
+}
+
+func TestFindCachedLoadsFromPrimary(t *testing.T) {
+	db := newCachedTestDatabase(t)
+
+	// A replica lagging behind the insert of the primary
+	lagging, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "replica.db")), &gorm.Config{Logger: db.Orm.Logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lagging.AutoMigrate(&cachedWidget{}); err != nil {
+		t.Fatal(err)
+	}
+	var pool *sql.DB
+	if pool, err = lagging.DB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	replicas := &replicaSet{primary: db.Orm.ConnPool, replicas: []*replica{{address: "replica", pool: pool}}}
+	replicas.replicas[0].healthy.Store(true)
+	if err := db.Orm.Callback().Query().Before("gorm:query").Register(replicaCallbackName, replicas.route); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := countCachedWidgets(t, db); got != 1 {
+		t.Fatalf("rows = %d, want the 1 on the primary", got)
+	}
+}
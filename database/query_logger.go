@@ -0,0 +1,220 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/deveusss/evergram-core/config"
+	"github.com/deveusss/evergram-core/logging"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// DefaultQueryBuckets are the upper bounds of the InMemoryQueryMetrics duration histogram
+var DefaultQueryBuckets = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 500 * time.Millisecond, time.Second, 5 * time.Second,
+}
+
+// QueryMetrics receives the duration of every statement, labelled by operation (select, insert, update,
+// delete or other). Adapt it to the metrics backend of the service.
+type QueryMetrics interface {
+	ObserveQuery(operation string, duration time.Duration, err error)
+}
+
+// QueryLoggerOptions tunes a QueryLogger
+type QueryLoggerOptions struct {
+	SlowThreshold time.Duration // Statements slower than this are logged as warnings, zero disables it
+	LogQueries    bool          // Log every statement at debug level
+	LogParams     bool          // Log bound values instead of placeholders
+}
+
+// QueryLogger is a gorm logger writing to slog. Failed and slow statements are logged with their SQL,
+// row count, duration and the trace ID of the context; bound values are left out unless LogParams is set.
+type QueryLogger struct {
+	log     *slog.Logger
+	level   logger.LogLevel
+	options QueryLoggerOptions
+	metrics *atomic.Pointer[QueryMetrics] // Shared by the copies made by LogMode
+}
+
+func NewQueryLogger(log *slog.Logger, options QueryLoggerOptions) *QueryLogger {
+	level := logger.Warn
+	if options.LogQueries {
+		level = logger.Info
+	}
+	return &QueryLogger{log: log, level: level, options: options, metrics: &atomic.Pointer[QueryMetrics]{}}
+}
+
+func queryLoggerOptions(dbConfig *config.DatabaseConfig) QueryLoggerOptions {
+	return QueryLoggerOptions{
+		SlowThreshold: dbConfig.SlowQueryThreshold,
+		LogQueries:    dbConfig.LogQueries,
+		LogParams:     dbConfig.LogQueryParams,
+	}
+}
+
+// SetQueryMetrics reports the duration of every statement to metrics, nil stops reporting
+func (db *OrmDatabase) SetQueryMetrics(metrics QueryMetrics) {
+	queryLogger, ok := db.Orm.Logger.(*QueryLogger)
+	if !ok {
+		db.slog.Warn("Query metrics need the database QueryLogger", "logger", fmt.Sprintf("%T", db.Orm.Logger))
+		return
+	}
+	queryLogger.SetMetrics(metrics)
+}
+
+// SetMetrics reports the duration of every statement to metrics, nil stops reporting
+func (l *QueryLogger) SetMetrics(metrics QueryMetrics) {
+	if metrics == nil {
+		l.metrics.Store(nil)
+		return
+	}
+	l.metrics.Store(&metrics)
+}
+
+// LogMode implements logger.Interface
+func (l *QueryLogger) LogMode(level logger.LogLevel) logger.Interface {
+	clone := *l
+	clone.level = level
+	return &clone
+}
+
+// Info implements logger.Interface
+func (l *QueryLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Info {
+		l.log.InfoContext(ctx, fmt.Sprintf(msg, data...), l.traceAttrs(ctx)...)
+	}
+}
+
+// Warn implements logger.Interface
+func (l *QueryLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Warn {
+		l.log.WarnContext(ctx, fmt.Sprintf(msg, data...), l.traceAttrs(ctx)...)
+	}
+}
+
+// Error implements logger.Interface
+func (l *QueryLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Error {
+		l.log.ErrorContext(ctx, fmt.Sprintf(msg, data...), l.traceAttrs(ctx)...)
+	}
+}
+
+// Trace implements logger.Interface, it runs after every statement
+func (l *QueryLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	elapsed := time.Since(begin)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil
+	}
+	failed := err != nil && l.level >= logger.Error
+	slow := l.options.SlowThreshold > 0 && elapsed > l.options.SlowThreshold && l.level >= logger.Warn
+	verbose := l.level >= logger.Info
+	metrics := l.metrics.Load()
+	if !failed && !slow && !verbose && metrics == nil {
+		return
+	}
+
+	sql, rows := fc()
+	if metrics != nil {
+		(*metrics).ObserveQuery(queryOperation(sql), elapsed, err)
+	}
+
+	attrs := append(l.traceAttrs(ctx), "sql", sql, "rows", rows, "duration", elapsed)
+	switch {
+	case failed:
+		l.log.ErrorContext(ctx, "Query failed", append(attrs, "err", err)...)
+	case slow:
+		l.log.WarnContext(ctx, "Slow query", append(attrs, "threshold", l.options.SlowThreshold)...)
+	case verbose:
+		l.log.DebugContext(ctx, "Query", attrs...)
+	}
+}
+
+// ParamsFilter implements gorm.ParamsFilter, dropping bound values so the logged SQL keeps its placeholders
+func (l *QueryLogger) ParamsFilter(_ context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	if l.options.LogParams {
+		return sql, params
+	}
+	return sql, nil
+}
+
+func (l *QueryLogger) traceAttrs(ctx context.Context) []interface{} {
+	if traceID, ok := logging.TraceIDFromContext(ctx); ok {
+		return []interface{}{"trace_id", traceID}
+	}
+	return nil
+}
+
+// queryOperation returns the lower-cased leading keyword of a statement, "other" for anything but DML
+func queryOperation(sql string) string {
+	keyword, _, _ := strings.Cut(strings.TrimSpace(sql), " ")
+	switch operation := strings.ToLower(keyword); operation {
+	case "select", "insert", "update", "delete":
+		return operation
+	default:
+		return "other"
+	}
+}
+
+// QueryStats aggregates the statements of one operation
+type QueryStats struct {
+	Count   int64
+	Errors  int64
+	Total   time.Duration
+	Buckets []int64 // Statements per DefaultQueryBuckets bound, above the previous one; the last entry counts the slower ones
+}
+
+// InMemoryQueryMetrics keeps counters and a duration histogram per operation, for tests and health endpoints
+type InMemoryQueryMetrics struct {
+	mu    sync.Mutex
+	stats map[string]*QueryStats
+}
+
+func NewInMemoryQueryMetrics() *InMemoryQueryMetrics {
+	return &InMemoryQueryMetrics{stats: map[string]*QueryStats{}}
+}
+
+// ObserveQuery implements QueryMetrics
+func (m *InMemoryQueryMetrics) ObserveQuery(operation string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats, ok := m.stats[operation]
+	if !ok {
+		stats = &QueryStats{Buckets: make([]int64, len(DefaultQueryBuckets)+1)}
+		m.stats[operation] = stats
+	}
+	stats.Count++
+	if err != nil {
+		stats.Errors++
+	}
+	stats.Total += duration
+	bucket := len(DefaultQueryBuckets)
+	for i, bound := range DefaultQueryBuckets {
+		if duration <= bound {
+			bucket = i
+			break
+		}
+	}
+	stats.Buckets[bucket]++
+}
+
+// Snapshot returns a copy of the statistics per operation
+func (m *InMemoryQueryMetrics) Snapshot() map[string]QueryStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := make(map[string]QueryStats, len(m.stats))
+	for operation, stats := range m.stats {
+		copied := *stats
+		copied.Buckets = append([]int64(nil), stats.Buckets...)
+		snapshot[operation] = copied
+	}
+	return snapshot
+}
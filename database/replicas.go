@@ -0,0 +1,212 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/deveusss/evergram-core/config"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ReplicaPolicyRoundRobin   = "round_robin"
+	ReplicaPolicyLeastLatency = "least_latency"
+
+	usePrimarySetting    = "evergram:use_primary"
+	replicaCallbackName  = "evergram:replica_routing"
+	replicaHealthTimeout = 2 * time.Second
+)
+
+// ReplicaStatus is the last observed health of a read replica
+type ReplicaStatus struct {
+	Address string        `json:"address"`
+	Healthy bool          `json:"healthy"`
+	Latency time.Duration `json:"latency_ns"`
+}
+
+type replica struct {
+	address string
+	pool    *sql.DB
+	healthy atomic.Bool
+	latency atomic.Int64 // nanoseconds of the last successful ping
+}
+
+// replicaSet routes plain reads to healthy replicas and falls back to the primary
+type replicaSet struct {
+	primary  gorm.ConnPool
+	replicas []*replica
+	policy   string
+	next     atomic.Uint64
+	stop     context.CancelFunc
+	done     chan struct{}
+	slog     *slog.Logger
+}
+
+// UsePrimary returns a copy of db whose reads go to the primary, e.g. to read your own writes
+func (db *OrmDatabase) UsePrimary() *OrmDatabase {
+	return db.withOrm(db.Orm.Set(usePrimarySetting, true).Session(&gorm.Session{}))
+}
+
+// ReplicaStatuses reports the health of every configured replica
+func (db *OrmDatabase) ReplicaStatuses() []ReplicaStatus {
+	if db.replicas == nil {
+		return nil
+	}
+	statuses := make([]ReplicaStatus, 0, len(db.replicas.replicas))
+	for _, r := range db.replicas.replicas {
+		statuses = append(statuses, ReplicaStatus{
+			Address: r.address,
+			Healthy: r.healthy.Load(),
+			Latency: time.Duration(r.latency.Load()),
+		})
+	}
+	return statuses
+}
+
+// connectReplicas opens every configured replica, starts their health checks
+// and registers the callback routing reads to them
+func (db *OrmDatabase) connectReplicas(dbConfig *config.DatabaseConfig) error {
+	rs := &replicaSet{
+		primary: db.Orm.ConnPool,
+		policy:  dbConfig.ReplicaPolicy,
+		done:    make(chan struct{}),
+		slog:    db.slog,
+	}
+
+	for _, endpoint := range dbConfig.Replicas {
+		replicaConfig := *dbConfig
+		replicaConfig.Host = endpoint.Host
+		replicaConfig.Port = endpoint.Port
+
+		_, dialector, err := openDialector(&replicaConfig)
+		if err != nil {
+			rs.closePools()
+			return err
+		}
+		// Replicas may be down at startup, health checks take care of them
+		orm, err := gorm.Open(dialector, &gorm.Config{DisableAutomaticPing: true})
+		if err != nil {
+			rs.closePools()
+			return err
+		}
+		pool, err := orm.DB()
+		if err != nil {
+			rs.closePools()
+			return err
+		}
+		configurePool(pool, dbConfig)
+		rs.replicas = append(rs.replicas, &replica{address: fmt.Sprintf("%s:%d", endpoint.Host, endpoint.Port), pool: pool})
+	}
+
+	if err := db.Orm.Callback().Query().Before("gorm:query").Register(replicaCallbackName, rs.route); err != nil {
+		rs.closePools()
+		return err
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	rs.stop = stop
+	rs.checkHealth(ctx)
+	go rs.healthLoop(ctx, dbConfig.ReplicaHealthCheckInterval)
+
+	db.replicas = rs
+	return nil
+}
+
+// route switches a query to a replica unless it runs in a transaction,
+// takes row locks or was explicitly pinned to the primary
+func (rs *replicaSet) route(tx *gorm.DB) {
+	if tx.Statement.ConnPool != rs.primary {
+		return
+	}
+	if usePrimary, ok := tx.Get(usePrimarySetting); ok && usePrimary == true {
+		return
+	}
+	if _, locking := tx.Statement.Clauses["FOR"]; locking {
+		return
+	}
+	if r := rs.pick(); r != nil {
+		tx.Statement.ConnPool = r.pool
+	}
+}
+
+// pick selects a healthy replica according to the policy, or nil when none is healthy
+func (rs *replicaSet) pick() *replica {
+	if rs.policy == ReplicaPolicyLeastLatency {
+		var best *replica
+		for _, r := range rs.replicas {
+			if r.healthy.Load() && (best == nil || r.latency.Load() < best.latency.Load()) {
+				best = r
+			}
+		}
+		return best
+	}
+
+	start := rs.next.Add(1)
+	for i := range rs.replicas {
+		r := rs.replicas[(start+uint64(i))%uint64(len(rs.replicas))]
+		if r.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+func (rs *replicaSet) healthLoop(ctx context.Context, interval time.Duration) {
+	defer close(rs.done)
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rs.checkHealth(ctx)
+		}
+	}
+}
+
+func (rs *replicaSet) checkHealth(ctx context.Context) {
+	for _, r := range rs.replicas {
+		pingCtx, cancel := context.WithTimeout(ctx, replicaHealthTimeout)
+		started := time.Now()
+		err := r.pool.PingContext(pingCtx)
+		cancel()
+
+		if err != nil {
+			if r.healthy.Swap(false) {
+				rs.slog.Warn("Replica is unhealthy", "replica", r.address, "err", err)
+			}
+			continue
+		}
+		r.latency.Store(int64(time.Since(started)))
+		if !r.healthy.Swap(true) {
+			rs.slog.Info("Replica is healthy", "replica", r.address)
+		}
+	}
+}
+
+// close stops the health checks and closes every replica pool
+func (rs *replicaSet) close() error {
+	rs.stop()
+	<-rs.done
+	return rs.closePools()
+}
+
+func (rs *replicaSet) closePools() error {
+	var firstErr error
+	for _, r := range rs.replicas {
+		if err := r.pool.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var ErrUnknownField = errors.New("unknown field")
+
+// Sort orders a listing by a field, given by its Go or column name
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+// ListOptions filters, sorts and paginates a listing
+type ListOptions struct {
+	Filter map[string]interface{} // Field equality conditions, slices match any of their values
+	Sort   []Sort
+	Limit  int // Zero means no limit
+	Offset int
+}
+
+// Repository provides CRUD operations for the model T.
+// Every method joins the transaction carried by ctx (see OrmDatabase.Context), and reads go
+// through the query cache when EnableCaching is on.
+type Repository[T any] struct {
+	db *OrmDatabase
+
+	schemaOnce sync.Once
+	schema     *schema.Schema
+	schemaErr  error
+}
+
+// NewRepository creates a Repository for T on db
+func NewRepository[T any](db *OrmDatabase) *Repository[T] {
+	return &Repository[T]{db: db}
+}
+
+// Get returns the row with the given primary key, or gorm.ErrRecordNotFound
+func (r *Repository[T]) Get(ctx context.Context, id interface{}) (*T, error) {
+	primaryKey, err := r.primaryKey()
+	if err != nil {
+		return nil, err
+	}
+	return FirstCached[T](ctx, r.conn(ctx), func(tx *gorm.DB) *gorm.DB {
+		return tx.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: primaryKey}, Value: id})
+	})
+}
+
+// List returns the rows matching opts
+func (r *Repository[T]) List(ctx context.Context, opts ListOptions) ([]T, error) {
+	filter, err := r.filter(opts.Filter)
+	if err != nil {
+		return nil, err
+	}
+	order := make([]clause.OrderByColumn, 0, len(opts.Sort))
+	for _, sort := range opts.Sort {
+		column, err := r.column(sort.Field)
+		if err != nil {
+			return nil, err
+		}
+		order = append(order, clause.OrderByColumn{Column: clause.Column{Table: clause.CurrentTable, Name: column}, Desc: sort.Desc})
+	}
+
+	return FindCached[T](ctx, r.conn(ctx), func(tx *gorm.DB) *gorm.DB {
+		if len(filter) > 0 {
+			tx = tx.Where(filter)
+		}
+		for _, column := range order {
+			tx = tx.Order(column)
+		}
+		if opts.Limit > 0 {
+			tx = tx.Limit(opts.Limit)
+		}
+		if opts.Offset > 0 {
+			tx = tx.Offset(opts.Offset)
+		}
+		return tx
+	})
+}
+
+// Create inserts entity and fills in its generated fields
+func (r *Repository[T]) Create(ctx context.Context, entity *T) error {
+	return r.conn(ctx).Orm.WithContext(ctx).Create(entity).Error
+}
+
+// Update saves entity by primary key. With a field mask only those fields are written, zero values included;
+// without one every non-zero field is written. gorm.ErrRecordNotFound is returned when no row has its key,
+// including rows of another tenant.
+func (r *Repository[T]) Update(ctx context.Context, entity *T, mask ...string) error {
+	tx := r.conn(ctx).Orm.WithContext(ctx).Model(entity)
+	if len(mask) > 0 {
+		columns := make([]string, 0, len(mask))
+		for _, field := range mask {
+			column, err := r.column(field)
+			if err != nil {
+				return err
+			}
+			columns = append(columns, column)
+		}
+		tx = tx.Select(columns)
+	}
+
+	result := tx.Updates(entity)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Delete soft deletes the row with the given primary key when T embeds gorm.Model (or a gorm.DeletedAt field),
+// and removes it otherwise
+func (r *Repository[T]) Delete(ctx context.Context, id interface{}) error {
+	return r.delete(r.conn(ctx).Orm.WithContext(ctx), id)
+}
+
+// HardDelete removes the row with the given primary key, bypassing soft delete
+func (r *Repository[T]) HardDelete(ctx context.Context, id interface{}) error {
+	return r.delete(r.conn(ctx).Orm.WithContext(ctx).Unscoped(), id)
+}
+
+// Exists reports whether any row matches filter
+func (r *Repository[T]) Exists(ctx context.Context, filter map[string]interface{}) (bool, error) {
+	count, err := r.Count(ctx, filter)
+	return count > 0, err
+}
+
+// Count returns the number of rows matching filter
+func (r *Repository[T]) Count(ctx context.Context, filter map[string]interface{}) (int64, error) {
+	conditions, err := r.filter(filter)
+	if err != nil {
+		return 0, err
+	}
+	return cachedQuery[T](ctx, r.conn(ctx), func(tx *gorm.DB) *gorm.DB {
+		if len(conditions) > 0 {
+			tx = tx.Where(conditions)
+		}
+		return tx
+	}, func(tx *gorm.DB, dest *int64) *gorm.DB {
+		return tx.Count(dest)
+	})
+}
+
+func (r *Repository[T]) delete(tx *gorm.DB, id interface{}) error {
+	primaryKey, err := r.primaryKey()
+	if err != nil {
+		return err
+	}
+	result := tx.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: primaryKey}, Value: id}).Delete(new(T))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// conn returns the transaction carried by ctx, falling back to the repository database
+func (r *Repository[T]) conn(ctx context.Context) *OrmDatabase {
+	if tx, ok := TransactionFromContext(ctx); ok {
+		return tx
+	}
+	return r.db
+}
+
+func (r *Repository[T]) parsedSchema() (*schema.Schema, error) {
+	r.schemaOnce.Do(func() {
+		statement := &gorm.Statement{DB: r.db.Orm}
+		r.schemaErr = statement.Parse(new(T))
+		r.schema = statement.Schema
+	})
+	return r.schema, r.schemaErr
+}
+
+func (r *Repository[T]) primaryKey() (string, error) {
+	parsed, err := r.parsedSchema()
+	if err != nil {
+		return "", err
+	}
+	if parsed.PrioritizedPrimaryField == nil {
+		return "", fmt.Errorf("%s has no primary key", parsed.Name)
+	}
+	return parsed.PrioritizedPrimaryField.DBName, nil
+}
+
+// column resolves a Go field or column name to its column, rejecting anything else so
+// caller-supplied names never reach the SQL unchecked
+func (r *Repository[T]) column(field string) (string, error) {
+	parsed, err := r.parsedSchema()
+	if err != nil {
+		return "", err
+	}
+	return lookupColumn(parsed, field)
+}
+
+// lookupColumn resolves a Go field or column name of s to its column
+func lookupColumn(s *schema.Schema, field string) (string, error) {
+	if f := s.LookUpField(field); f != nil && f.DBName != "" {
+		return f.DBName, nil
+	}
+	return "", fmt.Errorf("%w %q on %s", ErrUnknownField, field, s.Name)
+}
+
+func (r *Repository[T]) filter(filter map[string]interface{}) (map[string]interface{}, error) {
+	conditions := make(map[string]interface{}, len(filter))
+	for field, value := range filter {
+		column, err := r.column(field)
+		if err != nil {
+			return nil, err
+		}
+		conditions[column] = value
+	}
+	return conditions, nil
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type tenantItem struct {
+	ID       uint
+	TenantID string
+	Name     string
+}
+
+func TestRepositoryUpdateMissingRow(t *testing.T) {
+	db := newCachedTestDatabase(t)
+	db.config.TenantIsolation = true
+	if err := db.Orm.AutoMigrate(&tenantItem{}); err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository[tenantItem](db)
+	tenantA := WithTenant(context.Background(), "a")
+	tenantB := WithTenant(context.Background(), "b")
+
+	item := &tenantItem{Name: "first"}
+	if err := repo.Create(tenantA, item); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Update(tenantA, &tenantItem{ID: item.ID + 1, Name: "missing"}, "Name"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("Update of a missing id err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+	if err := repo.Update(tenantB, &tenantItem{ID: item.ID, Name: "stolen"}, "Name"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("Update of another tenant's row err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+	if err := repo.Update(tenantA, &tenantItem{ID: item.ID, Name: "second"}, "Name"); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := repo.Get(tenantA, item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Name != "second" {
+		t.Fatalf("name = %q, want second", stored.Name)
+	}
+}
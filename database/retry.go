@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/eapache/go-resiliency/retrier"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Postgres SQLSTATE codes treated as transient
+const (
+	sqlStateSerializationFailure = "40001"
+	sqlStateDeadlockDetected     = "40P01"
+	sqlStateAdminShutdown        = "57P01"
+	sqlStateCrashShutdown        = "57P02"
+	sqlStateCannotConnectNow     = "57P03"
+	sqlStateConnectionException  = "08" // class prefix
+)
+
+// TransientErrorClassifier is a retrier.Classifier that only retries errors
+// which may succeed when the same work is attempted again.
+type TransientErrorClassifier struct{}
+
+// Classify implements retrier.Classifier
+func (TransientErrorClassifier) Classify(err error) retrier.Action {
+	if err == nil {
+		return retrier.Succeed
+	}
+	if IsTransientError(err) {
+		return retrier.Retry
+	}
+	return retrier.Fail
+}
+
+// SerializationErrorClassifier is a retrier.Classifier that only retries serialization failures and deadlocks,
+// the errors after which a whole transaction can safely be replayed.
+type SerializationErrorClassifier struct{}
+
+// Classify implements retrier.Classifier
+func (SerializationErrorClassifier) Classify(err error) retrier.Action {
+	if err == nil {
+		return retrier.Succeed
+	}
+	if IsSerializationError(err) {
+		return retrier.Retry
+	}
+	return retrier.Fail
+}
+
+// IsSerializationError reports whether err is a serialization failure (40001) or a deadlock (40P01)
+func IsSerializationError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == sqlStateSerializationFailure || pgErr.Code == sqlStateDeadlockDetected
+	}
+	return false
+}
+
+// IsTransientError reports whether err is a connection failure, serialization failure, deadlock
+// or server shutdown. Constraint violations and every other server error are permanent.
+func IsTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case sqlStateSerializationFailure, sqlStateDeadlockDetected,
+			sqlStateAdminShutdown, sqlStateCrashShutdown, sqlStateCannotConnectNow:
+			return true
+		}
+		return strings.HasPrefix(pgErr.Code, sqlStateConnectionException)
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	if pgconn.SafeToRetry(err) || pgconn.Timeout(err) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// WithRetry runs fn through the configured Retrier, re-running it on transient errors.
+// fn must be idempotent. Inside a transaction fn runs once, as a failed statement aborts the transaction.
+func (db *OrmDatabase) WithRetry(ctx context.Context, fn func(*OrmDatabase) error) error {
+	return db.runRetryable(ctx, func(ctx context.Context) error {
+		return fn(db.withOrm(db.Orm.WithContext(ctx)))
+	})
+}
+
+func (db *OrmDatabase) runRetryable(ctx context.Context, work func(context.Context) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if db.Retry == nil || db.InTransaction() {
+		return work(ctx)
+	}
+	return db.Retry.RunCtx(ctx, work)
+}
+
+// retryBackoff returns the backoff schedule configured by MaxRetries and RetryWait
+func (db *OrmDatabase) retryBackoff() []time.Duration {
+	if db.config == nil || db.config.MaxRetries <= 0 || db.config.RetryWait <= 0 {
+		return nil
+	}
+	return retrier.ExponentialBackoff(db.config.MaxRetries, db.config.RetryWait)
+}
+
+// backoffWait returns the wait before the retry following attempts failures, staying at the last step of
+// backoff once exhausted so callers that never give up keep a bounded wait
+func backoffWait(backoff []time.Duration, attempts int, fallback time.Duration) time.Duration {
+	if len(backoff) == 0 {
+		return fallback
+	}
+	if attempts >= len(backoff) {
+		return backoff[len(backoff)-1]
+	}
+	return backoff[attempts]
+}
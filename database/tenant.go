@@ -0,0 +1,226 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	TenantColumn  = "tenant_id"
+	TenantSetting = "app.tenant_id" // Postgres setting read by the row level security policies
+
+	tenantCallbackName = "evergram:tenant_scope"
+	tenantPolicyName   = "tenant_isolation"
+)
+
+var (
+	ErrMissingTenant  = errors.New("missing tenant")
+	ErrTenantMismatch = errors.New("row belongs to another tenant")
+)
+
+type tenantKey struct{}
+type tenantBypassKey struct{}
+
+// WithTenant returns a context scoping every query made with it to tenantID
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// TenantFromContext returns the tenant set by WithTenant
+func TenantFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	tenantID, ok := ctx.Value(tenantKey{}).(string)
+	return tenantID, ok && tenantID != ""
+}
+
+// WithoutTenant returns a context whose queries see the rows of every tenant, for admin and maintenance jobs.
+// With row level security the database role must also bypass the policies (BYPASSRLS).
+func WithoutTenant(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantBypassKey{}, true)
+}
+
+func tenantBypassed(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	bypass, _ := ctx.Value(tenantBypassKey{}).(bool)
+	return bypass
+}
+
+// EnableRowLevelSecurity installs a policy restricting tables to the rows of the tenant in app.tenant_id.
+// The setting is only present inside WithTransactionContext, so with TenantRowLevelSecurity on every
+// access to these tables must happen in a transaction. Postgres only.
+func (db *OrmDatabase) EnableRowLevelSecurity(ctx context.Context, tables ...string) error {
+	if db.Orm.Dialector.Name() != DriverPostgres {
+		return fmt.Errorf("row level security is not supported by %s", db.Orm.Dialector.Name())
+	}
+	return db.WithTransactionContext(ctx, func(tx *OrmDatabase) error {
+		for _, table := range tables {
+			quoted := tx.Orm.Statement.Quote(table)
+			condition := fmt.Sprintf("%s::text = current_setting('%s', true)", TenantColumn, TenantSetting)
+			statements := []string{
+				fmt.Sprintf("ALTER TABLE %s ENABLE ROW LEVEL SECURITY", quoted),
+				fmt.Sprintf("ALTER TABLE %s FORCE ROW LEVEL SECURITY", quoted),
+				fmt.Sprintf("DROP POLICY IF EXISTS %s ON %s", tenantPolicyName, quoted),
+				fmt.Sprintf("CREATE POLICY %s ON %s USING (%s) WITH CHECK (%s)", tenantPolicyName, quoted, condition, condition),
+			}
+			for _, statement := range statements {
+				if err := tx.Orm.WithContext(ctx).Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (db *OrmDatabase) tenantIsolation() bool {
+	return db.config != nil && db.config.TenantIsolation
+}
+
+// applyTenantSetting exposes the tenant of ctx to the row level security policies for the rest of tx
+func (db *OrmDatabase) applyTenantSetting(ctx context.Context, tx *gorm.DB) error {
+	if db.config == nil || !db.config.TenantRowLevelSecurity || db.Orm.Dialector.Name() != DriverPostgres {
+		return nil
+	}
+	tenantID, ok := TenantFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	// set_config(..., true) is SET LOCAL with a bind parameter
+	return tx.Exec("SELECT set_config(?, ?, true)", TenantSetting, tenantID).Error
+}
+
+// registerTenantCallbacks scopes queries, updates and deletes on tables with a tenant_id column
+// to the tenant of the statement context, and stamps it on created rows
+func (db *OrmDatabase) registerTenantCallbacks() error {
+	callbacks := db.Orm.Callback()
+	if err := callbacks.Create().Before("gorm:create").Register(tenantCallbackName, db.assignTenant); err != nil {
+		return err
+	}
+	if err := callbacks.Query().Before("gorm:query").Register(tenantCallbackName, db.scopeTenant); err != nil {
+		return err
+	}
+	if err := callbacks.Row().Before("gorm:row").Register(tenantCallbackName, db.scopeTenant); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").Register(tenantCallbackName, db.scopeTenantWrite); err != nil {
+		return err
+	}
+	return callbacks.Delete().Before("gorm:delete").Register(tenantCallbackName, db.scopeTenantWrite)
+}
+
+// statementTenant returns the tenant to apply to the statement, or false when the model has no tenant column,
+// isolation is off or the context opted out. A missing tenant fails the statement.
+func (db *OrmDatabase) statementTenant(tx *gorm.DB) (string, bool) {
+	if tx.Error != nil {
+		return "", false
+	}
+	tenantID, ok, err := db.schemaTenant(tx.Statement.Context, tx.Statement.Schema)
+	if err != nil {
+		tx.AddError(err)
+	}
+	return tenantID, ok
+}
+
+// schemaTenant is statementTenant for code writing rows outside of gorm callbacks
+func (db *OrmDatabase) schemaTenant(ctx context.Context, s *schema.Schema) (string, bool, error) {
+	if !db.tenantIsolation() || s == nil {
+		return "", false, nil
+	}
+	if field := s.LookUpField(TenantColumn); field == nil || field.DBName != TenantColumn {
+		return "", false, nil
+	}
+	if tenantBypassed(ctx) {
+		return "", false, nil
+	}
+	tenantID, ok := TenantFromContext(ctx)
+	if !ok {
+		return "", false, fmt.Errorf("%w for %s", ErrMissingTenant, s.Table)
+	}
+	return tenantID, true, nil
+}
+
+func (db *OrmDatabase) scopeTenant(tx *gorm.DB) {
+	tenantID, ok := db.statementTenant(tx)
+	if !ok {
+		return
+	}
+	tx.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: TenantColumn}, Value: tenantID},
+	}})
+}
+
+// scopeTenantWrite leaves unconditioned updates and deletes for gorm to reject with ErrMissingWhereClause,
+// rather than turning them into tenant-wide ones
+func (db *OrmDatabase) scopeTenantWrite(tx *gorm.DB) {
+	_, hasWhere := tx.Statement.Clauses["WHERE"]
+	if !hasWhere && !tx.Statement.AllowGlobalUpdate && tx.Statement.Schema != nil && !primaryKeySet(tx.Statement) {
+		return
+	}
+	db.scopeTenant(tx)
+}
+
+func (db *OrmDatabase) assignTenant(tx *gorm.DB) {
+	tenantID, ok := db.statementTenant(tx)
+	if !ok {
+		return
+	}
+	switch rows := reflect.Indirect(tx.Statement.ReflectValue); rows.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rows.Len(); i++ {
+			if err := stampTenant(tx.Statement.Context, tx.Statement.Schema, reflect.Indirect(rows.Index(i)), tenantID); err != nil {
+				tx.AddError(err)
+			}
+		}
+	case reflect.Struct:
+		if err := stampTenant(tx.Statement.Context, tx.Statement.Schema, rows, tenantID); err != nil {
+			tx.AddError(err)
+		}
+	case reflect.Map:
+		if values, ok := tx.Statement.Dest.(map[string]interface{}); ok {
+			if value, set := values[TenantColumn]; !set {
+				values[TenantColumn] = tenantID
+			} else if fmt.Sprint(value) != tenantID {
+				tx.AddError(fmt.Errorf("%w: %s %v", ErrTenantMismatch, tx.Statement.Table, value))
+			}
+		}
+	}
+}
+
+// stampTenant sets the tenant column of row when empty and rejects rows of another tenant
+func stampTenant(ctx context.Context, s *schema.Schema, row reflect.Value, tenantID string) error {
+	field := s.LookUpField(TenantColumn)
+	value, zero := field.ValueOf(ctx, row)
+	if zero {
+		return field.Set(ctx, row, tenantID)
+	}
+	if fmt.Sprint(value) != tenantID {
+		return fmt.Errorf("%w: %s %v", ErrTenantMismatch, s.Table, value)
+	}
+	return nil
+}
+
+// primaryKeySet reports whether gorm will condition the statement on the primary key of its model
+func primaryKeySet(statement *gorm.Statement) bool {
+	field := statement.Schema.PrioritizedPrimaryField
+	if field == nil {
+		return false
+	}
+	switch rows := reflect.Indirect(statement.ReflectValue); rows.Kind() {
+	case reflect.Struct:
+		_, zero := field.ValueOf(statement.Context, rows)
+		return !zero
+	case reflect.Slice, reflect.Array:
+		return rows.Len() > 0
+	}
+	return false
+}
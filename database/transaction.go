@@ -0,0 +1,167 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/eapache/go-resiliency/retrier"
+)
+
+type transactionKey struct{}
+
+// TransactionFromContext returns the transaction-scoped OrmDatabase carried by a context obtained from Context()
+func TransactionFromContext(ctx context.Context) (*OrmDatabase, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	tx, ok := ctx.Value(transactionKey{}).(*OrmDatabase)
+	return tx, ok
+}
+
+// Context returns the context db was bound to. Inside a transaction it carries the transaction,
+// letting code that only receives a context, such as Repository, join it.
+func (db *OrmDatabase) Context() context.Context {
+	if db.ctx == nil {
+		return context.Background()
+	}
+	return db.ctx
+}
+
+// InTransaction reports whether db is bound to an open transaction
+func (db *OrmDatabase) InTransaction() bool {
+	return db.txDepth > 0
+}
+
+// WithTransactionContext executes a function inside a transaction with context
+func (db *OrmDatabase) WithTransactionContext(ctx context.Context, fn func(*OrmDatabase) error) error {
+	return db.WithTransactionOptions(ctx, nil, fn)
+}
+
+// WithTransactionOptions executes fn inside a transaction started with opts (isolation level, read-only).
+// The transaction is rolled back when fn returns an error or panics, the panic being re-raised afterwards.
+// When db is already inside a transaction fn runs within a savepoint instead and opts are ignored,
+// so an error only discards the work done by fn.
+// With TenantRowLevelSecurity the tenant of ctx is set as app.tenant_id for the transaction.
+// Cached query results of the tables written are invalidated once the transaction commits.
+func (db *OrmDatabase) WithTransactionOptions(ctx context.Context, opts *sql.TxOptions, fn func(*OrmDatabase) error) error {
+	if db.InTransaction() {
+		return db.withSavepoint(ctx, fn)
+	}
+
+	tx := db.Orm.WithContext(ctx).Begin(opts)
+	if tx.Error != nil {
+		db.slog.Error("Error beginning transaction", "err", tx.Error)
+		return tx.Error
+	}
+	if err := db.applyTenantSetting(ctx, tx); err != nil {
+		db.slog.Error("Error setting transaction tenant", "err", err)
+		tx.Rollback()
+		return err
+	}
+
+	if db.invalidations != nil {
+		db.invalidations.begin(tx.Statement.ConnPool)
+		defer db.invalidations.end(tx.Statement.ConnPool)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			if err := tx.Rollback().Error; err != nil {
+				db.slog.Error("Error rolling back transaction", "err", err)
+			}
+			db.slog.Error("Panic in transaction", "panic", p)
+			panic(p)
+		}
+	}()
+
+	txDb := db.withOrm(tx)
+	txDb.txDepth = 1
+	txDb.ctx = context.WithValue(ctx, transactionKey{}, txDb)
+	if err := fn(txDb); err != nil {
+		if rollbackErr := tx.Rollback().Error; rollbackErr != nil {
+			db.slog.Error("Error rolling back transaction", "err", rollbackErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		db.slog.Error("Error committing transaction", "err", err)
+		return err
+	}
+	if db.invalidations != nil {
+		if tables := db.invalidations.end(tx.Statement.ConnPool); len(tables) > 0 {
+			if err := db.InvalidateCache(tables...); err != nil {
+				db.slog.Warn("Error invalidating query cache", "tables", tables, "err", err)
+			}
+		}
+	}
+	return nil
+}
+
+// WithSerializableTransaction executes fn inside a SERIALIZABLE transaction and replays the whole transaction,
+// with the configured retry backoff, when Postgres aborts it with a serialization failure or a deadlock.
+// fn receives a fresh transaction-scoped OrmDatabase on every attempt and must not leak state between attempts.
+// When db is already inside a transaction fn runs once in a savepoint and the error is left to the outermost caller.
+func (db *OrmDatabase) WithSerializableTransaction(ctx context.Context, fn func(*OrmDatabase) error) error {
+	if db.InTransaction() {
+		return db.withSavepoint(ctx, fn)
+	}
+
+	opts := &sql.TxOptions{Isolation: sql.LevelSerializable}
+	attempts := 0
+	err := db.serializationRetrier().RunFn(ctx, func(ctx context.Context, retries int) error {
+		attempts = retries + 1
+		err := db.WithTransactionOptions(ctx, opts, fn)
+		if err != nil && IsSerializationError(err) {
+			db.slog.Warn("Serializable transaction aborted", "attempt", attempts, "err", err)
+		}
+		return err
+	})
+	if err != nil {
+		db.slog.Error("Serializable transaction failed", "attempts", attempts, "err", err)
+		return err
+	}
+	if attempts > 1 {
+		db.slog.Info("Serializable transaction committed after retries", "attempts", attempts)
+	}
+	return nil
+}
+
+func (db *OrmDatabase) serializationRetrier() *retrier.Retrier {
+	return retrier.New(db.retryBackoff(), SerializationErrorClassifier{})
+}
+
+func (db *OrmDatabase) withSavepoint(ctx context.Context, fn func(*OrmDatabase) error) error {
+	name := fmt.Sprintf("sp_%d", db.txDepth)
+	tx := db.Orm.WithContext(ctx)
+	if err := tx.SavePoint(name).Error; err != nil {
+		db.slog.Error("Error creating savepoint", "savepoint", name, "err", err)
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			if err := tx.RollbackTo(name).Error; err != nil {
+				db.slog.Error("Error rolling back to savepoint", "savepoint", name, "err", err)
+			}
+			panic(p)
+		}
+	}()
+
+	txDb := db.withOrm(tx)
+	txDb.txDepth = db.txDepth + 1
+	txDb.ctx = context.WithValue(ctx, transactionKey{}, txDb)
+	if err := fn(txDb); err != nil {
+		if rollbackErr := tx.RollbackTo(name).Error; rollbackErr != nil {
+			db.slog.Error("Error rolling back to savepoint", "savepoint", name, "err", rollbackErr)
+		}
+		return err
+	}
+
+	if err := tx.Exec("RELEASE SAVEPOINT " + name).Error; err != nil {
+		db.slog.Error("Error releasing savepoint", "savepoint", name, "err", err)
+		return err
+	}
+	return nil
+}
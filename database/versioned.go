@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm/clause"
+)
+
+const versionColumn = "version"
+
+// ErrConcurrentModification is returned when a versioned row changed since it was read.
+// Handlers should answer it with HTTP 409 Conflict.
+var ErrConcurrentModification = errors.New("concurrent modification")
+
+// Versioned adds an optimistic locking version to a model, embed it alongside gorm.Model
+type Versioned struct {
+	Version uint64 `gorm:"not null;default:1"`
+}
+
+// VersionedModel is implemented by pointers to models embedding Versioned
+type VersionedModel interface {
+	GetVersion() uint64
+	SetVersion(version uint64)
+}
+
+func (v *Versioned) GetVersion() uint64 {
+	return v.Version
+}
+
+func (v *Versioned) SetVersion(version uint64) {
+	v.Version = version
+}
+
+// UpdateVersioned saves model only if its row still has the version it was read with, and bumps the version.
+// With a field mask only those fields are written, zero values included; without one every non-zero field is.
+// ErrConcurrentModification is returned, and model left untouched, when the row was changed or deleted meanwhile.
+func (db *OrmDatabase) UpdateVersioned(ctx context.Context, model VersionedModel, mask ...string) error {
+	current := model.GetVersion()
+	model.SetVersion(current + 1)
+
+	tx := db.Orm.WithContext(ctx).Model(model).
+		Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: versionColumn}, Value: current})
+	if len(mask) > 0 {
+		tx = tx.Select(append(append([]string(nil), mask...), versionColumn))
+	}
+
+	result := tx.Updates(model)
+	if result.Error != nil {
+		model.SetVersion(current)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		model.SetVersion(current)
+		return fmt.Errorf("%w: %s at version %d", ErrConcurrentModification, tx.Statement.Table, current)
+	}
+	return nil
+}
+
+// DeleteVersioned deletes model only if its row still has the version it was read with
+func (db *OrmDatabase) DeleteVersioned(ctx context.Context, model VersionedModel) error {
+	current := model.GetVersion()
+	tx := db.Orm.WithContext(ctx).
+		Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: versionColumn}, Value: current})
+
+	result := tx.Delete(model)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: %s at version %d", ErrConcurrentModification, tx.Statement.Table, current)
+	}
+	return nil
+}
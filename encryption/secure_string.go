@@ -1,6 +1,8 @@
 package encryption
 
 import (
+	"encoding/json"
+	"log/slog"
 	"math/rand"
 	"time"
 )
@@ -115,3 +117,41 @@ func (s *SecureString) IsEqual(other ISecureString) bool {
 const (
 	DefaultKey = 12345
 )
+
+// Redacted is printed in place of secure string values
+const Redacted = "******"
+
+// String implements fmt.Stringer without revealing the value
+func (s SecureString) String() string {
+	return Redacted
+}
+
+// GoString implements fmt.GoStringer without revealing the value
+func (s SecureString) GoString() string {
+	return Redacted
+}
+
+// LogValue implements slog.LogValuer without revealing the value
+func (s SecureString) LogValue() slog.Value {
+	return slog.StringValue(Redacted)
+}
+
+// MarshalJSON implements json.Marshaler without revealing the value
+func (s SecureString) MarshalJSON() ([]byte, error) {
+	return json.Marshal(Redacted)
+}
+
+// SetValue implements cleanenv.Setter so secure strings can be read from environment variables
+func (s *SecureString) SetValue(value string) error {
+	*s = *NewSecureString(value).(*SecureString)
+	return nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler so secure strings can be read from configuration files
+func (s *SecureString) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	return s.SetValue(value)
+}
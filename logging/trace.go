@@ -0,0 +1,19 @@
+package logging
+
+import "context"
+
+type traceIDKey struct{}
+
+// WithTraceID returns a context carrying the trace ID of the current request
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceIDFromContext returns the trace ID set by WithTraceID
+func TraceIDFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	traceID, ok := ctx.Value(traceIDKey{}).(string)
+	return traceID, ok && traceID != ""
+}